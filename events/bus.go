@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Publish stores an event in the outbox. It takes the transaction of the change the event describes, so the event
+// exists exactly when the change was committed.
+func Publish(tx *gorm.DB, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.OutboxEvent{
+		Name:          event.EventName(),
+		Payload:       string(payload),
+		Status:        models.OutboxPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// Handler reacts to a stored event. Events are delivered at least once, so handlers have to cope with seeing the
+// same event again, which happens whenever any handler of the event failed.
+type Handler func(ctx context.Context, event Event) error
+
+type eventIDKey struct{}
+
+// Returns the outbox ID of the event a handler was called with. It stays the same when the event is delivered again,
+// so handlers can use it to recognize events they already handled.
+func EventID(ctx context.Context) uint {
+	id, _ := ctx.Value(eventIDKey{}).(uint)
+	return id
+}
+
+type subscription struct {
+	name    string
+	handler Handler
+}
+
+// Bus holds the subscribers of every event and decodes stored events for them.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string][]subscription
+	decoders    map[string]func([]byte) (Event, error)
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: map[string][]subscription{},
+		decoders:    map[string]func([]byte) (Event, error){},
+	}
+}
+
+// The bus the dispatcher delivers to
+var DefaultBus = NewBus()
+
+// Subscribe registers handler for events of type E under a name used in logs and errors. Subscribers have to be
+// registered before the dispatcher starts.
+func Subscribe[E Event](bus *Bus, name string, handler func(ctx context.Context, event E) error) {
+	var zero E
+	eventName := zero.EventName()
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.decoders[eventName] = func(payload []byte) (Event, error) {
+		var event E
+		err := json.Unmarshal(payload, &event)
+		return event, err
+	}
+	bus.subscribers[eventName] = append(bus.subscribers[eventName], subscription{
+		name: name,
+		handler: func(ctx context.Context, event Event) error {
+			return handler(ctx, event.(E))
+		},
+	})
+}
+
+// Runs every subscriber of a stored event, even when an earlier one failed, and returns the first failure.
+func (b *Bus) deliver(ctx context.Context, name string, payload []byte) error {
+	b.mu.RLock()
+	decode, ok := b.decoders[name]
+	subscribers := b.subscribers[name]
+	b.mu.RUnlock()
+	if !ok {
+		// Nobody is interested in the event
+		return nil
+	}
+
+	event, err := decode(payload)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", name, err)
+	}
+	var firstErr error
+	for _, subscriber := range subscribers {
+		if err := subscriber.handler(ctx, event); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", subscriber.name, err)
+		}
+	}
+	return firstErr
+}
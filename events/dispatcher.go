@@ -0,0 +1,97 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Events whose subscribers keep failing are retried after 10s, 20s, 40s and so on, up to this many attempts in total
+const (
+	MaxEventAttempts = 10
+	initialBackoff   = 10 * time.Second
+	maxBackoff       = time.Hour
+)
+
+// Events claimed by a dispatcher that did not finish within this time are picked up again
+const eventLockTimeout = 5 * time.Minute
+
+const claimEventsQuery = `
+UPDATE outbox_events SET locked_until = ?
+WHERE id IN (
+	SELECT id FROM outbox_events
+	WHERE status = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+	ORDER BY id
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// Dispatcher hands stored events to the subscribers on a bus. Several dispatchers may run at once, every event is
+// claimed before it is delivered.
+type Dispatcher struct {
+	DB  *gorm.DB
+	Bus *Bus
+}
+
+// Delivers up to limit events that are due, oldest first, and returns how many were attempted.
+func (d *Dispatcher) DispatchDue(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+	var claimed []models.OutboxEvent
+	err := d.DB.Raw(claimEventsQuery, now.Add(eventLockTimeout), models.OutboxPending, now, now, limit).Scan(&claimed).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range claimed {
+		if err := d.dispatch(ctx, event); err != nil {
+			log.Printf("Could not record outbox event %d: %v", event.ID, err)
+		}
+	}
+	return len(claimed), nil
+}
+
+// Delivers a single event and records the outcome. The returned error is about recording it, failing subscribers
+// are handled by scheduling a retry.
+func (d *Dispatcher) dispatch(ctx context.Context, event models.OutboxEvent) error {
+	attempts := event.Attempts + 1
+	err := d.Bus.deliver(context.WithValue(ctx, eventIDKey{}, event.ID), event.Name, []byte(event.Payload))
+	if err == nil {
+		return d.DB.Model(&event).Updates(map[string]interface{}{
+			"status":       models.OutboxProcessed,
+			"attempts":     attempts,
+			"processed_at": time.Now(),
+			"locked_until": nil,
+			"last_error":   "",
+		}).Error
+	}
+
+	log.Printf("Outbox event %d (%s) failed: %v", event.ID, event.Name, err)
+	if attempts >= MaxEventAttempts {
+		return d.DB.Model(&event).Updates(map[string]interface{}{
+			"status":       models.OutboxFailed,
+			"attempts":     attempts,
+			"locked_until": nil,
+			"last_error":   err.Error(),
+		}).Error
+	}
+	backoff := initialBackoff << (attempts - 1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return d.DB.Model(&event).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": time.Now().Add(backoff),
+		"locked_until":    nil,
+		"last_error":      err.Error(),
+	}).Error
+}
+
+// Deletes processed events older than retention. Failed events are kept so they can be looked into.
+func PurgeProcessed(db *gorm.DB, retention time.Duration) error {
+	return db.Where("status = ? AND processed_at < ?", models.OutboxProcessed, time.Now().Add(-retention)).
+		Delete(&models.OutboxEvent{}).Error
+}
@@ -0,0 +1,71 @@
+package events
+
+// Event is a domain event. Its name identifies it in the outbox and is what subscribers subscribe to, so it must not
+// change once events were stored.
+type Event interface {
+	EventName() string
+}
+
+const (
+	UserCreatedEvent           = "user.created"
+	UserFollowedEvent          = "user.followed"
+	FollowRequestedEvent       = "follow.requested"
+	FriendRequestAcceptedEvent = "friend_request.accepted"
+	PostCreatedEvent           = "post.created"
+	MessageSentEvent           = "message.sent"
+	GroupJoinedEvent           = "group.joined"
+)
+
+type UserCreated struct {
+	UserID uint `json:"userID"`
+}
+
+func (UserCreated) EventName() string { return UserCreatedEvent }
+
+// UserFollowed is published when a follow becomes active: right away for public accounts, once the request is
+// approved for private ones.
+type UserFollowed struct {
+	FollowerID uint `json:"followerID"`
+	FolloweeID uint `json:"followeeID"`
+}
+
+func (UserFollowed) EventName() string { return UserFollowedEvent }
+
+// FollowRequested is published when someone asks to follow a private account, which may still reject them.
+type FollowRequested struct {
+	FollowerID uint `json:"followerID"`
+	FolloweeID uint `json:"followeeID"`
+}
+
+func (FollowRequested) EventName() string { return FollowRequestedEvent }
+
+type FriendRequestAccepted struct {
+	RequesterID uint `json:"requesterID"`
+	AccepterID  uint `json:"accepterID"`
+}
+
+func (FriendRequestAccepted) EventName() string { return FriendRequestAcceptedEvent }
+
+type PostCreated struct {
+	PostID   uint `json:"postID"`
+	UserID   uint `json:"userID"`
+	SenderID uint `json:"senderID"`
+}
+
+func (PostCreated) EventName() string { return PostCreatedEvent }
+
+type MessageSent struct {
+	MessageID   uint `json:"messageID"`
+	SenderID    uint `json:"senderID"`
+	RecipientID uint `json:"recipientID"`
+}
+
+func (MessageSent) EventName() string { return MessageSentEvent }
+
+type GroupJoined struct {
+	GroupID uint `json:"groupID"`
+	UserID  uint `json:"userID"`
+	OwnerID uint `json:"ownerID"`
+}
+
+func (GroupJoined) EventName() string { return GroupJoinedEvent }
@@ -0,0 +1,252 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+type Profile struct {
+	ID                    uint
+	FirstName             string
+	MiddleName            string
+	LastName              string
+	Username              string
+	Email                 string
+	Mobile                string
+	Intro                 string
+	IsPrivate             bool
+	EmailVisibility       int
+	MobileVisibility      int
+	IntroVisibility       int
+	ConnectionsVisibility int
+	RegisteredAt          time.Time
+	LastLogin             time.Time
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	// Where the profile images are in the archive, empty if the user has none or they were missing from storage
+	Avatar    string `gorm:"-"`
+	Cover     string `gorm:"-"`
+	AvatarKey string `json:"-"`
+	CoverKey  string `json:"-"`
+}
+
+type Post struct {
+	ID uint
+	// The user whose wall the post is on, and the user who wrote it
+	UserID    uint
+	SenderID  uint
+	Message   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Comment struct {
+	ID        uint
+	PostID    uint
+	ParentID  *uint
+	Message   string
+	CreatedAt time.Time
+}
+
+type Reaction struct {
+	PostID    uint
+	Kind      string
+	CreatedAt time.Time
+}
+
+type Message struct {
+	ID                 uint
+	MessageSenderID    uint
+	MessageRecipientID uint
+	Message            string
+	CreatedAt          time.Time
+}
+
+type GroupMessage struct {
+	ID        uint
+	GroupID   uint
+	Message   string
+	CreatedAt time.Time
+}
+
+type GroupMembership struct {
+	GroupID   uint
+	Status    int
+	CreatedAt time.Time
+}
+
+type Friend struct {
+	SourceID  uint
+	TargetID  uint
+	Status    int
+	CreatedAt time.Time
+}
+
+type Follower struct {
+	SourceID  uint
+	TargetID  uint
+	CreatedAt time.Time
+}
+
+// Session is a login of the user. The token itself is left out of the archive.
+type Session struct {
+	ID        uint
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type Attachment struct {
+	ID             uint
+	ContentType    string
+	Size           int64
+	PostID         *uint
+	MessageID      *uint
+	GroupMessageID *uint
+	CreatedAt      time.Time
+	// Where the file is in the archive, empty if it was missing from storage
+	File string
+}
+
+// The WriteArchive function writes a zip archive of everything stored about a user to w. Every kind of data goes to
+// a JSON file of its own, the profile images go to the profile directory and the other files the user uploaded to
+// the attachments directory.
+func WriteArchive(ctx context.Context, db *gorm.DB, store storage.BlobStore, userID uint, w io.Writer) error {
+	db = db.WithContext(ctx)
+	archive := zip.NewWriter(w)
+
+	var profile Profile
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Take(&profile).Error; err != nil {
+		return err
+	}
+	var err error
+	if profile.Avatar, err = copyProfileImage(ctx, archive, store, profile.AvatarKey, "avatar"); err != nil {
+		return err
+	}
+	if profile.Cover, err = copyProfileImage(ctx, archive, store, profile.CoverKey, "cover"); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	var posts []Post
+	var comments []Comment
+	var reactions []Reaction
+	var messages []Message
+	var groupMessages []GroupMessage
+	var groups []GroupMembership
+	var friends []Friend
+	var followers, following []Follower
+	var tokens []models.Token
+	var attachments []models.Attachment
+	queries := []struct {
+		file   string
+		query  *gorm.DB
+		result interface{}
+	}{
+		{"posts.json", db.Model(&models.Post{}).Where("user_id = ? OR sender_id = ?", userID, userID), &posts},
+		{"comments.json", db.Model(&models.PostComment{}).Where("user_id = ?", userID), &comments},
+		{"reactions.json", db.Model(&models.PostReaction{}).Where("user_id = ?", userID), &reactions},
+		{"messages.json", db.Model(&models.Message{}).Where("message_sender_id = ? OR message_recipient_id = ?", userID, userID), &messages},
+		{"group_messages.json", db.Model(&models.GroupMessage{}).Where("user_id = ?", userID), &groupMessages},
+		{"groups.json", db.Model(&models.GroupMember{}).Where("user_id = ?", userID), &groups},
+		{"friends.json", db.Model(&models.UserFriend{}).Where("source_id = ? OR target_id = ?", userID, userID), &friends},
+		{"followers.json", db.Model(&models.UserFollower{}).Where("target_id = ?", userID), &followers},
+		{"following.json", db.Model(&models.UserFollower{}).Where("source_id = ?", userID), &following},
+		{"", db.Where("user_id = ?", userID), &tokens},
+		{"", db.Where("owner_id = ?", userID), &attachments},
+	}
+	for _, q := range queries {
+		if err := q.query.Order("created_at, id").Find(q.result).Error; err != nil {
+			return err
+		}
+		if q.file == "" {
+			continue
+		}
+		if err := writeJSON(archive, q.file, q.result); err != nil {
+			return err
+		}
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, Session{ID: token.ID, CreatedAt: token.CreatedAt, ExpiresAt: time.Unix(token.ExpiresAt, 0)})
+	}
+	if err := writeJSON(archive, "sessions.json", sessions); err != nil {
+		return err
+	}
+
+	attachmentEntries := make([]Attachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		file := fmt.Sprintf("attachments/%d%s", attachment.ID, path.Ext(attachment.StorageKey))
+		err := copyFile(ctx, archive, store, attachment.StorageKey, file)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Listed without a file rather than failing the whole export
+			file = ""
+		} else if err != nil {
+			return err
+		}
+		attachmentEntries = append(attachmentEntries, Attachment{
+			ID:             attachment.ID,
+			ContentType:    attachment.ContentType,
+			Size:           attachment.Size,
+			PostID:         attachment.PostID,
+			MessageID:      attachment.MessageID,
+			GroupMessageID: attachment.GroupMessageID,
+			CreatedAt:      attachment.CreatedAt,
+			File:           file,
+		})
+	}
+	if err := writeJSON(archive, "attachments.json", attachmentEntries); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// Copies a profile image into the profile directory and returns where it is in the archive.
+func copyProfileImage(ctx context.Context, archive *zip.Writer, store storage.BlobStore, key string, name string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	file := "profile/" + name + path.Ext(key)
+	err := copyFile(ctx, archive, store, key, file)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil
+	}
+	return file, err
+}
+
+// Copies a stored file into the archive. Media is already compressed, so it is stored as it is.
+func copyFile(ctx context.Context, archive *zip.Writer, store storage.BlobStore, key string, name string) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	return err
+}
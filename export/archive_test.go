@@ -0,0 +1,80 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestWriteArchiveProfileImages(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cover was never stored, so only the avatar can be copied
+	err = db.Callback().Query().After("gorm:query").Register("test:profile", func(tx *gorm.DB) {
+		if profile, ok := tx.Statement.Dest.(*Profile); ok {
+			*profile = Profile{ID: 1, FirstName: "Ana", AvatarKey: "avatars/1/a.jpg", CoverKey: "covers/1/c.png"}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "avatars/1/a.jpg", strings.NewReader("avatar"), 6, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteArchive(context.Background(), db, store, 1, &buffer); err != nil {
+		t.Fatalf("WriteArchive() error = %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(content)
+	}
+
+	if files["profile/avatar.jpg"] != "avatar" {
+		t.Errorf("profile/avatar.jpg = %q, want the stored avatar", files["profile/avatar.jpg"])
+	}
+	for name := range files {
+		if strings.HasPrefix(name, "profile/cover") {
+			t.Errorf("archive contains %s, which is missing from storage", name)
+		}
+	}
+	var profile map[string]interface{}
+	if err := json.Unmarshal([]byte(files["profile.json"]), &profile); err != nil {
+		t.Fatalf("profile.json: %v", err)
+	}
+	if profile["Avatar"] != "profile/avatar.jpg" || profile["Cover"] != "" {
+		t.Errorf("profile images = %v, %v, want profile/avatar.jpg and none", profile["Avatar"], profile["Cover"])
+	}
+	if _, ok := profile["AvatarKey"]; ok {
+		t.Error("profile.json contains the storage key of the avatar")
+	}
+}
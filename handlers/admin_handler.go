@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/queue"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RequireAdmin only lets admins through. It has to run after the auth middleware.
+func RequireAdmin(c *fiber.Ctx) error {
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	isAdmin, err := models.IsAdmin(database.DB.Db, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not check permissions", err)
+	}
+	if !isAdmin {
+		return handleError(c, fiber.StatusForbidden, "Only admins can make this request.", nil)
+	}
+	return c.Next()
+}
+
+// Lists background jobs, newest first. They can be filtered with the "status", "type" and "queue" query parameters.
+func GetJobs(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+
+	query := db.Model(&models.Job{})
+	if status := c.Query("status"); status != "" {
+		if !slices.Contains(models.JobStatuses, status) {
+			return handleError(c, fiber.StatusBadRequest, "Unknown job status "+status, nil)
+		}
+		query = query.Where("status = ?", status)
+	}
+	if jobType := c.Query("type"); jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+	if queueName := c.Query("queue"); queueName != "" {
+		query = query.Where("queue = ?", queueName)
+	}
+
+	var found []models.Job
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&found).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve jobs", err)
+	}
+	jobResponses := make([]models.JobResponse, 0, len(found))
+	for _, job := range found {
+		jobResponses = append(jobResponses, newJobResponse(job))
+	}
+	return c.JSON(fiber.Map{
+		"jobs": jobResponses,
+	})
+}
+
+// Returns how many jobs every queue has in every status.
+func GetJobStats(c *fiber.Ctx) error {
+	db := database.DB.Db
+
+	var counts []models.JobCount
+	err := db.Model(&models.Job{}).Select("queue, status, COUNT(*) AS count").Group("queue, status").Order("queue, status").
+		Scan(&counts).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve job stats", err)
+	}
+	return c.JSON(fiber.Map{
+		"counts": counts,
+	})
+}
+
+func GetJob(c *fiber.Ctx) error {
+	db := database.DB.Db
+	jobID, _ := strconv.Atoi(c.Params("jobID"))
+
+	var job models.Job
+	err := db.First(&job, jobID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Job not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve job", err)
+	}
+	return c.JSON(newJobResponse(job))
+}
+
+// Runs a dead or pending job again right away, with a fresh set of attempts.
+func RetryJob(c *fiber.Ctx) error {
+	db := database.DB.Db
+	jobID, _ := strconv.Atoi(c.Params("jobID"))
+
+	retried, err := queue.Retry(db, uint(jobID))
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retry job", err)
+	}
+	if !retried {
+		return handleError(c, fiber.StatusConflict, "Only dead or pending jobs can be retried.", nil)
+	}
+
+	var job models.Job
+	if err := db.First(&job, jobID).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve job", err)
+	}
+	return c.JSON(newJobResponse(job))
+}
+
+// Lists the runs of scheduled maintenance tasks, newest first. They can be filtered with the "task" query parameter.
+func GetTaskRuns(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+
+	query := db.Model(&models.TaskRun{})
+	if task := c.Query("task"); task != "" {
+		query = query.Where("task = ?", task)
+	}
+	var runs []models.TaskRun
+	if err := query.Order("scheduled_at DESC, id DESC").Limit(limit).Offset(offset).Find(&runs).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve task runs", err)
+	}
+	return c.JSON(fiber.Map{
+		"runs": runs,
+	})
+}
+
+func newJobResponse(job models.Job) models.JobResponse {
+	return models.JobResponse{
+		ID:          job.ID,
+		Queue:       job.Queue,
+		Type:        job.Type,
+		Payload:     []byte(job.Payload),
+		Status:      job.Status,
+		RunAt:       job.RunAt,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		LastError:   job.LastError,
+		StartedAt:   job.StartedAt,
+		FinishedAt:  job.FinishedAt,
+		CreatedAt:   job.CreatedAt,
+	}
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func CreateComment(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.CommentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	postID, _ := strconv.Atoi(c.Params("postID"))
+	post, err := findVisiblePost(db, viewerID, uint(postID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Post not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find post", err)
+	}
+
+	comment := models.PostComment{
+		PostID:  post.ID,
+		UserID:  viewerID,
+		Message: request.Message,
+	}
+	var parentAuthorID uint
+	if request.ParentID != nil {
+		var parent models.PostComment
+		err := db.Where("id = ? AND post_id = ?", *request.ParentID, post.ID).First(&parent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return handleError(c, fiber.StatusNotFound, "Parent comment not found", err)
+		}
+		if err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not find parent comment", err)
+		}
+		// Only one level of replies exists, so replying to a reply answers the comment it belongs to
+		if parent.ParentID != nil {
+			comment.ParentID = parent.ParentID
+		} else {
+			comment.ParentID = &parent.ID
+		}
+		parentAuthorID = parent.UserID
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		if comment.ParentID != nil {
+			err := tx.Model(&models.PostComment{}).Where("id = ?", *comment.ParentID).
+				UpdateColumn("reply_count", gorm.Expr("reply_count + 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := models.IncrementPostCommentCount(tx, post.ID, 1); err != nil {
+			return err
+		}
+
+		// Replies notify whoever they answer, top-level comments the owner of the post
+		if comment.ParentID != nil {
+			return notifications.Notify(tx, []uint{parentAuthorID}, viewerID, models.NotificationReply, models.SubjectComment, *comment.ParentID)
+		}
+		return notifications.Notify(tx, []uint{post.UserID}, viewerID, models.NotificationComment, models.SubjectPost, post.ID)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create comment", err)
+	}
+
+	// The author is shown with the comment, like in the listings
+	if err := db.First(&comment.User, viewerID).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create comment", err)
+	}
+	commentResponses, err := buildCommentResponses(db, viewerID, []models.PostComment{comment})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create comment", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(commentResponses[0])
+}
+
+// Returns the top-level comments of a post, oldest first. Replies are fetched per comment with GetCommentReplies.
+func GetPostComments(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	postID, _ := strconv.Atoi(c.Params("postID"))
+	post, err := findVisiblePost(db, viewerID, uint(postID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Post not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find post", err)
+	}
+
+	var comments []models.PostComment
+	err = db.Preload("User").Where("post_id = ? AND parent_id IS NULL", post.ID).
+		Where("user_id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).
+		Order("created_at, id").Limit(limit).Offset(offset).Find(&comments).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve comments", err)
+	}
+
+	commentResponses, err := buildCommentResponses(db, viewerID, comments)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve comments", err)
+	}
+	return c.JSON(fiber.Map{
+		"comments": commentResponses,
+	})
+}
+
+func GetCommentReplies(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	commentID, _ := strconv.Atoi(c.Params("commentID"))
+	comment, err := findVisibleComment(db, viewerID, uint(commentID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Comment not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find comment", err)
+	}
+
+	var replies []models.PostComment
+	err = db.Preload("User").Where("parent_id = ?", comment.ID).
+		Where("user_id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).
+		Order("created_at, id").Limit(limit).Offset(offset).Find(&replies).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve replies", err)
+	}
+
+	commentResponses, err := buildCommentResponses(db, viewerID, replies)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve replies", err)
+	}
+	return c.JSON(fiber.Map{
+		"replies": commentResponses,
+	})
+}
+
+// Edits the message of a comment. Only its author may do so.
+func UpdateComment(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.CommentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	commentID, _ := strconv.Atoi(c.Params("commentID"))
+	comment, err := findVisibleComment(db, viewerID, uint(commentID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Comment not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find comment", err)
+	}
+	if comment.UserID != viewerID {
+		return handleError(c, fiber.StatusForbidden, "You are not authorized to make this request.", nil)
+	}
+
+	if err := db.Model(&comment).Update("message", request.Message).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update comment", err)
+	}
+
+	commentResponses, err := buildCommentResponses(db, viewerID, []models.PostComment{comment})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update comment", err)
+	}
+	return c.JSON(commentResponses[0])
+}
+
+// Deletes a comment together with its replies. Both the author of the comment and the owner of the post may do so,
+// so users can moderate the discussion under their own posts.
+func DeleteComment(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	commentID, _ := strconv.Atoi(c.Params("commentID"))
+	var comment models.PostComment
+	err = db.Preload("Post").First(&comment, commentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Comment not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find comment", err)
+	}
+	if comment.UserID != viewerID && comment.Post.UserID != viewerID {
+		return handleError(c, fiber.StatusForbidden, "You are not authorized to make this request.", nil)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return deleteComment(tx, comment)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete comment", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Comment deleted successfully.",
+	})
+}
+
+// Soft-deletes a comment and its replies and keeps the reply and comment counters in step.
+// It has to run inside a transaction.
+func deleteComment(tx *gorm.DB, comment models.PostComment) error {
+	deleted := int64(0)
+	if comment.ParentID == nil {
+		replies := tx.Where("parent_id = ?", comment.ID).Delete(&models.PostComment{})
+		if replies.Error != nil {
+			return replies.Error
+		}
+		deleted += replies.RowsAffected
+	}
+
+	result := tx.Delete(&comment)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	deleted += result.RowsAffected
+
+	if comment.ParentID != nil {
+		err := tx.Model(&models.PostComment{}).Where("id = ?", *comment.ParentID).
+			UpdateColumn("reply_count", gorm.Expr("GREATEST(reply_count - 1, 0)")).Error
+		if err != nil {
+			return err
+		}
+	}
+	return models.IncrementPostCommentCount(tx, comment.PostID, -int(deleted))
+}
+
+// Loads a comment on a post the viewer is allowed to see, reporting hidden ones as gorm.ErrRecordNotFound.
+func findVisibleComment(db *gorm.DB, viewerID uint, commentID uint) (models.PostComment, error) {
+	var comment models.PostComment
+	if err := db.Preload("User").First(&comment, commentID).Error; err != nil {
+		return comment, err
+	}
+	// The author deleted their account
+	if comment.User.ID == 0 {
+		return comment, gorm.ErrRecordNotFound
+	}
+	if _, err := findVisiblePost(db, viewerID, comment.PostID); err != nil {
+		return comment, err
+	}
+	blocked, err := models.IsBlocked(db, viewerID, comment.UserID)
+	if err != nil {
+		return comment, err
+	}
+	if blocked {
+		return comment, gorm.ErrRecordNotFound
+	}
+	return comment, nil
+}
+
+func buildCommentResponses(db *gorm.DB, viewerID uint, comments []models.PostComment) ([]models.CommentResponse, error) {
+	users := make([]models.User, 0, len(comments))
+	for _, comment := range comments {
+		users = append(users, comment.User)
+	}
+	userProfiles, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return nil, err
+	}
+
+	commentResponses := make([]models.CommentResponse, 0, len(comments))
+	for i, comment := range comments {
+		commentResponses = append(commentResponses, models.CommentResponse{
+			ID:         comment.ID,
+			PostID:     comment.PostID,
+			ParentID:   comment.ParentID,
+			Author:     userProfiles[i],
+			Message:    comment.Message,
+			ReplyCount: comment.ReplyCount,
+			CreatedAt:  comment.CreatedAt,
+			UpdatedAt:  comment.UpdatedAt,
+		})
+	}
+	return commentResponses, nil
+}
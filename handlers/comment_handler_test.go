@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCreateCommentReturnsAuthor(t *testing.T) {
+	useTestDB(t)
+	status, body := send(t, fiber.MethodPost, "/posts/:postID/comments", "/posts/5/comments", `{"Message":"Nice"}`, CreateComment)
+	if status != fiber.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", status, fiber.StatusCreated, body)
+	}
+	var comment models.CommentResponse
+	if err := json.Unmarshal(body, &comment); err != nil {
+		t.Fatal(err)
+	}
+	if comment.Author.ID != 1 || comment.Author.FirstName != "Ana" {
+		t.Errorf("Author = %+v, want user 1", comment.Author)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/jobs"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Users can request one data export in this period, failed ones aside
+const dataExportInterval = 24 * time.Hour
+
+// Starts building an archive of everything stored about the requesting user. They are notified once it can be
+// downloaded, see GetDataExports.
+func RequestDataExport(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var previous models.DataExport
+	err = db.Where("user_id = ? AND status <> ? AND created_at > ?", userID, models.ExportFailed, time.Now().Add(-dataExportInterval)).
+		Order("id DESC").First(&previous).Error
+	if err == nil && previous.Status == models.ExportPending {
+		return handleError(c, fiber.StatusConflict, "A data export is already being prepared.", nil)
+	}
+	if err == nil {
+		return handleError(c, fiber.StatusTooManyRequests, "Data exports can be requested once a day.", nil)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusInternalServerError, "Could not request data export", err)
+	}
+
+	dataExport := models.DataExport{UserID: userID, Status: models.ExportPending}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dataExport).Error; err != nil {
+			return err
+		}
+		return jobs.EnqueueBuildDataExport(tx, dataExport.ID)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not request data export", err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(newDataExportResponse(dataExport))
+}
+
+// Lists the data exports of the requesting user, newest first.
+func GetDataExports(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var dataExports []models.DataExport
+	if err := db.Where("user_id = ?", userID).Order("id DESC").Limit(limit).Offset(offset).Find(&dataExports).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve data exports", err)
+	}
+	dataExportResponses := make([]models.DataExportResponse, 0, len(dataExports))
+	for _, dataExport := range dataExports {
+		dataExportResponses = append(dataExportResponses, newDataExportResponse(dataExport))
+	}
+	return c.JSON(fiber.Map{
+		"exports": dataExportResponses,
+	})
+}
+
+// Returns a data export of the requesting user, with a fresh download link once it is ready.
+func GetDataExport(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	exportID, _ := strconv.Atoi(c.Params("exportID"))
+	var dataExport models.DataExport
+	err = db.Where("id = ? AND user_id = ?", exportID, userID).First(&dataExport).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Data export not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve data export", err)
+	}
+	return c.JSON(newDataExportResponse(dataExport))
+}
+
+func newDataExportResponse(dataExport models.DataExport) models.DataExportResponse {
+	response := models.DataExportResponse{
+		ID:          dataExport.ID,
+		Status:      dataExport.Status,
+		Size:        dataExport.Size,
+		CreatedAt:   dataExport.CreatedAt,
+		CompletedAt: dataExport.CompletedAt,
+		ExpiresAt:   dataExport.ExpiresAt,
+	}
+	// The archive is deleted by a scheduled task, which may run a while after the export expired
+	if dataExport.Status == models.ExportReady && dataExport.ExpiresAt != nil && time.Now().Before(*dataExport.ExpiresAt) {
+		response.DownloadURL = storage.SignedURL(dataExport.StorageKey)
+	}
+	return response
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Returns the friends the requesting user and :id have in common.
+func GetMutualFriends(c *fiber.Ctx) error {
+	return listMutualConnections(c, "mutual_friends", models.FriendIDs)
+}
+
+// Returns the users following both the requesting user and :id.
+func GetMutualFollowers(c *fiber.Ctx) error {
+	return listMutualConnections(c, "mutual_followers", models.FollowerIDs)
+}
+
+func listMutualConnections(c *fiber.Ctx, key string, connections func(*gorm.DB, uint) *gorm.DB) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var owner models.User
+	ownerID, _ := strconv.Atoi(c.Params("id"))
+	if err := db.Scopes(models.ActiveUsers).First(&owner, ownerID).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	allowed, err := canViewConnections(db, viewerID, owner)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve connections", err)
+	}
+	if !allowed {
+		return handleError(c, fiber.StatusForbidden, "This user's connections are private.", nil)
+	}
+
+	var users []models.User
+	err = db.Where("id IN (?) AND id IN (?)", connections(db, viewerID), connections(db, owner.ID)).
+		Where("id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).
+		Order("id").Limit(limit).Offset(offset).Find(&users).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve connections", err)
+	}
+
+	userProfiles, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve connections", err)
+	}
+	return c.JSON(fiber.Map{
+		key: userProfiles,
+	})
+}
+
+// Returns how many friendship hops separate the requesting user from :id, and the users along the shortest path.
+// The search depth is capped by the "max_hops" query parameter, which itself cannot exceed MaxSeparationHops. The
+// path is left out when the viewer may not see the connections of a user in between.
+func GetDegreeOfSeparation(c *fiber.Ctx) error {
+	db := database.DB.Db
+	targetID, _ := strconv.Atoi(c.Params("id"))
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	maxHops := c.QueryInt("max_hops", models.DefaultSeparationHops)
+	if maxHops < 1 || maxHops > models.MaxSeparationHops {
+		return handleError(c, fiber.StatusBadRequest, "max_hops must be between 1 and "+strconv.Itoa(models.MaxSeparationHops), nil)
+	}
+
+	var target models.User
+	err = db.Scopes(models.ActiveUsers).First(&target, targetID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+	blocked, err := models.IsBlocked(db, viewerID, target.ID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+	if blocked {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", nil)
+	}
+
+	friendPath, found, err := models.ShortestFriendPath(db, viewerID, target.ID, maxHops)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not compute degree of separation", err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{
+			"connected": false,
+			"max_hops":  maxHops,
+		})
+	}
+
+	// Load the users on the path and put them back in path order
+	var users []models.User
+	if err := db.Scopes(models.ActiveUsers).Where("id IN ?", friendPath.Path).Find(&users).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not compute degree of separation", err)
+	}
+	usersByID := map[uint]models.User{}
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+	orderedUsers := make([]models.User, 0, len(friendPath.Path))
+	for _, userID := range friendPath.Path {
+		user, ok := usersByID[userID]
+		if !ok {
+			// Deactivated or deleted since the path was found
+			return separationDegree(c, friendPath.Depth)
+		}
+		orderedUsers = append(orderedUsers, user)
+	}
+	// The path reveals the friendships of every user in between, so it is only shown when the viewer could read
+	// their friend lists anyway
+	for _, user := range orderedUsers[1 : len(orderedUsers)-1] {
+		allowed, err := canViewConnections(db, viewerID, user)
+		if err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not compute degree of separation", err)
+		}
+		if !allowed {
+			return separationDegree(c, friendPath.Depth)
+		}
+	}
+	userProfiles, err := projectUserProfiles(db, viewerID, orderedUsers)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not compute degree of separation", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"connected": true,
+		"degree":    friendPath.Depth,
+		"path":      userProfiles,
+	})
+}
+
+// Answers a separation request without the path.
+func separationDegree(c *fiber.Ctx, degree int) error {
+	return c.JSON(fiber.Map{
+		"connected": true,
+		"degree":    degree,
+	})
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/events"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Creates a group owned by the requesting user, who becomes its first member.
+func CreateGroup(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.GroupRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+	if !slugPattern.MatchString(request.Slug) {
+		return handleError(c, fiber.StatusBadRequest, "Slugs may only contain lowercase letters, digits and single hyphens", nil)
+	}
+
+	var existing int64
+	if err := db.Model(&models.Group{}).Where("slug = ?", request.Slug).Count(&existing).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create group", err)
+	}
+	if existing > 0 {
+		return handleError(c, fiber.StatusConflict, "This slug is already taken.", nil)
+	}
+
+	group := models.Group{
+		CreatedByID: userID,
+		UpdatedByID: userID,
+		Title:       request.Title,
+		Slug:        request.Slug,
+		Summary:     request.Summary,
+		Content:     request.Content,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.GroupMember{GroupID: group.ID, UserID: userID}).Error
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create group", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(models.GroupResponse{
+		ID:          group.ID,
+		Title:       group.Title,
+		Slug:        group.Slug,
+		Summary:     group.Summary,
+		Content:     group.Content,
+		CreatedByID: group.CreatedByID,
+		MemberCount: 1,
+		IsMember:    true,
+		CreatedAt:   group.CreatedAt,
+	})
+}
+
+func GetGroup(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	group, err := findGroup(db, c.Params("groupID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Group not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+
+	var memberCount int64
+	if err := db.Model(&models.GroupMember{}).Where("group_id = ?", group.ID).Count(&memberCount).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+	isMember, err := models.IsGroupMember(db, group.ID, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+	return c.JSON(models.GroupResponse{
+		ID:          group.ID,
+		Title:       group.Title,
+		Slug:        group.Slug,
+		Summary:     group.Summary,
+		Content:     group.Content,
+		CreatedByID: group.CreatedByID,
+		MemberCount: memberCount,
+		IsMember:    isMember,
+		CreatedAt:   group.CreatedAt,
+	})
+}
+
+func JoinGroup(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	group, err := findGroup(db, c.Params("groupID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Group not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+
+	isMember, err := models.IsGroupMember(db, group.ID, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not join group", err)
+	}
+	if isMember {
+		return handleError(c, fiber.StatusConflict, "You are already a member of this group.", nil)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.GroupMember{GroupID: group.ID, UserID: userID}).Error; err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.GroupJoined{GroupID: group.ID, UserID: userID, OwnerID: group.CreatedByID}); err != nil {
+			return err
+		}
+		return notifications.Notify(tx, []uint{group.CreatedByID}, userID, models.NotificationGroupJoin, models.SubjectGroup, group.ID)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not join group", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"detail": "Joined group successfully.",
+	})
+}
+
+// Leaves a group. The owner cannot leave their own group.
+func LeaveGroup(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	group, err := findGroup(db, c.Params("groupID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Group not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+	if group.CreatedByID == userID {
+		return handleError(c, fiber.StatusBadRequest, "The owner cannot leave their own group.", nil)
+	}
+
+	result := db.Where("group_id = ? AND user_id = ?", group.ID, userID).Delete(&models.GroupMember{})
+	if result.Error != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not leave group", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return handleError(c, fiber.StatusNotFound, "You are not a member of this group.", nil)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Left group successfully.",
+	})
+}
+
+// Returns the messages of a group, newest first. Only members can read them.
+func GetGroupMessages(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	group, err := findMemberGroup(db, c.Params("groupID"), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Group not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+
+	var messages []models.GroupMessage
+	err = db.Preload("Attachments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Attachments.Variants").Where("group_id = ?", group.ID).
+		Order("created_at DESC").Limit(limit).Offset(offset).Find(&messages).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve messages", err)
+	}
+
+	messageResponses := make([]models.GroupMessageResponse, 0, len(messages))
+	for _, message := range messages {
+		messageResponses = append(messageResponses, newGroupMessageResponse(message))
+	}
+	return c.JSON(fiber.Map{
+		"messages": messageResponses,
+	})
+}
+
+func SendGroupMessage(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.MessageRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	group, err := findMemberGroup(db, c.Params("groupID"), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Group not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+
+	message := models.GroupMessage{
+		GroupID: group.ID,
+		UserID:  userID,
+		Message: request.Message,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if err := models.ClaimAttachments(tx, userID, request.AttachmentIDs, models.AttachmentGroupMessageColumn, message.ID); err != nil {
+			return err
+		}
+		return syncGroupMessageTags(tx, message)
+	})
+	if errors.Is(err, models.ErrInvalidAttachments) {
+		return handleError(c, fiber.StatusBadRequest, "Invalid attachments", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not send message", err)
+	}
+	if err := db.Preload("Variants").Where("group_message_id = ?", message.ID).Order("id").Find(&message.Attachments).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not send message", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(newGroupMessageResponse(message))
+}
+
+// Edits the text of a group message. Only its author may do so, as long as they are still a member.
+func UpdateGroupMessage(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.MessageUpdateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	group, err := findMemberGroup(db, c.Params("groupID"), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Group not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find group", err)
+	}
+
+	messageID, _ := strconv.Atoi(c.Params("messageID"))
+	var message models.GroupMessage
+	err = db.Where("id = ? AND group_id = ? AND user_id = ?", messageID, group.ID, userID).First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Message not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find message", err)
+	}
+
+	message.Message = request.Message
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&message).Update("message", message.Message).Error; err != nil {
+			return err
+		}
+		return syncGroupMessageTags(tx, message)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update message", err)
+	}
+	if err := db.Preload("Variants").Where("group_message_id = ?", message.ID).Order("id").Find(&message.Attachments).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update message", err)
+	}
+	return c.JSON(newGroupMessageResponse(message))
+}
+
+func findGroup(db *gorm.DB, groupIDParam string) (models.Group, error) {
+	var group models.Group
+	groupID, _ := strconv.Atoi(groupIDParam)
+	err := db.First(&group, groupID).Error
+	return group, err
+}
+
+// Loads a group the user is a member of. Groups the user did not join are reported as gorm.ErrRecordNotFound.
+func findMemberGroup(db *gorm.DB, groupIDParam string, userID uint) (models.Group, error) {
+	group, err := findGroup(db, groupIDParam)
+	if err != nil {
+		return group, err
+	}
+	isMember, err := models.IsGroupMember(db, group.ID, userID)
+	if err != nil {
+		return group, err
+	}
+	if !isMember {
+		return group, gorm.ErrRecordNotFound
+	}
+	return group, nil
+}
+
+// Updates the hashtags and mentions of a group message and notifies the newly mentioned users who are members.
+func syncGroupMessageTags(tx *gorm.DB, message models.GroupMessage) error {
+	mentionedIDs, err := models.SyncGroupMessageTags(tx, message)
+	if err != nil || len(mentionedIDs) == 0 {
+		return err
+	}
+
+	var recipientIDs []uint
+	err = tx.Model(&models.GroupMember{}).Where("group_id = ? AND user_id IN ?", message.GroupID, mentionedIDs).
+		Distinct().Pluck("user_id", &recipientIDs).Error
+	if err != nil {
+		return err
+	}
+	return notifications.Notify(tx, recipientIDs, message.UserID, models.NotificationMention, models.SubjectGroupMessage, message.ID)
+}
+
+func newGroupMessageResponse(message models.GroupMessage) models.GroupMessageResponse {
+	return models.GroupMessageResponse{
+		ID:          message.ID,
+		GroupID:     message.GroupID,
+		UserID:      message.UserID,
+		Message:     message.Message,
+		CreatedAt:   message.CreatedAt,
+		UpdatedAt:   message.UpdatedAt,
+		Attachments: newAttachmentResponses(message.Attachments),
+	}
+}
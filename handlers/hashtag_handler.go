@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultTrendingHours = 24
+	maxTrendingHours     = 7 * 24
+	defaultTrendingLimit = 10
+)
+
+// Hashtags are ranked by the number of posts that used them within the window. Only posts of public accounts count,
+// so that the trends do not reveal what private accounts post about.
+const trendingHashtagsQuery = `
+SELECT hashtags.name, count(*) AS post_count
+FROM post_hashtags
+	JOIN hashtags ON hashtags.id = post_hashtags.hashtag_id
+	JOIN posts ON posts.id = post_hashtags.post_id AND posts.deleted_at IS NULL
+	JOIN users ON users.id = posts.user_id AND NOT users.is_private
+WHERE post_hashtags.created_at > ?
+GROUP BY hashtags.name
+ORDER BY post_count DESC, hashtags.name
+LIMIT ?`
+
+// Returns the newest posts with the hashtag that the requesting user may see.
+func GetHashtagPosts(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	tag := strings.ToLower(strings.TrimPrefix(c.Params("tag"), "#"))
+
+	following := models.FollowingIDs(db, viewerID)
+	hidden := models.HiddenUserIDs(db, viewerID)
+
+	var posts []models.Post
+	err = db.Joins("JOIN post_hashtags ON post_hashtags.post_id = posts.id").
+		Joins("JOIN hashtags ON hashtags.id = post_hashtags.hashtag_id").
+		Where("hashtags.name = ?", tag).
+		Where("posts.user_id NOT IN (?) AND posts.sender_id NOT IN (?)", hidden, hidden).
+		// Posts of private accounts are only shown to their approved followers
+		Where("posts.user_id = ? OR posts.user_id IN (?) OR posts.user_id NOT IN (SELECT id FROM users WHERE is_private)", viewerID, following).
+		Order("posts.created_at DESC").Limit(limit).Offset(offset).Find(&posts).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve posts", err)
+	}
+
+	postResponses, err := buildPostResponses(db, viewerID, posts)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve posts", err)
+	}
+	return c.JSON(fiber.Map{
+		"hashtag": tag,
+		"posts":   postResponses,
+	})
+}
+
+// Returns the most used hashtags of the last "hours" hours, 24 by default.
+func GetTrendingHashtags(c *fiber.Ctx) error {
+	db := database.DB.Db
+	hours := c.QueryInt("hours", defaultTrendingHours)
+	if hours <= 0 || hours > maxTrendingHours {
+		return handleError(c, fiber.StatusBadRequest, "hours must be between 1 and 168", nil)
+	}
+	limit := c.QueryInt("limit", defaultTrendingLimit)
+	if limit <= 0 || limit > maxPageLimit {
+		limit = defaultTrendingLimit
+	}
+
+	hashtags := []models.TrendingHashtag{}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	if err := db.Raw(trendingHashtagsQuery, since, limit).Scan(&hashtags).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve trending hashtags", err)
+	}
+	return c.JSON(fiber.Map{
+		"hashtags": hashtags,
+	})
+}
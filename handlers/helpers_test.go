@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Points the handlers at a database that runs no statements and returns the SQL of every query, update and insert
+// they make. Users and posts that are looked up come back as user 1, Ana, and post 5 of user 1.
+func useTestDB(t *testing.T) *[]string {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: testConnPool{}}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statements []string
+	record := func(tx *gorm.DB) {
+		statements = append(statements, tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...))
+		dest := tx.Statement.Dest
+		// models.QueryAndReturnError passes a pointer to its interface{} argument
+		if wrapped, ok := dest.(*interface{}); ok {
+			dest = *wrapped
+		}
+		switch dest := dest.(type) {
+		case *models.User:
+			dest.ID, dest.FirstName = 1, "Ana"
+		case *[]*models.User:
+			*dest = append(*dest, &models.User{Model: gorm.Model{ID: 1}, FirstName: "Ana"})
+		case *models.Post:
+			*dest = models.Post{UserID: 1, SenderID: 1}
+			dest.ID = 5
+		}
+	}
+	if err := db.Callback().Query().After("gorm:query").Before("gorm:preload").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Row().After("gorm:row").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Create().After("gorm:create").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database.DB.Db
+	database.DB.Db = db
+	t.Cleanup(func() { database.DB.Db = previous })
+	return &statements
+}
+
+// Sends a request authenticated as user 1 to the handler and returns the status and body of the response.
+func send(t *testing.T, method string, route string, target string, body string, handler fiber.Handler) (int, []byte) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test")
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"ID": 1}).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Add(method, route, handler)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, response
+}
+
+// Connection of the test database. Statements never reach it, but transactions are begun and committed on it.
+type testConnPool struct{}
+
+func (testConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, sql.ErrConnDone
+}
+
+func (testConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, sql.ErrConnDone
+}
+
+func (testConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, sql.ErrConnDone
+}
+
+func (testConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func (p testConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return p, nil
+}
+
+func (testConnPool) Commit() error {
+	return nil
+}
+
+func (testConnPool) Rollback() error {
+	return nil
+}
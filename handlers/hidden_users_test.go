@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Users who deleted their account keep their rows until the purge job runs, so listings have to leave them out
+// explicitly. The subquery must not inherit the soft delete condition of the User model, which would drop them.
+func TestListingsHideDeletedUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		target  string
+		handler fiber.Handler
+		table   string
+	}{
+		{"timeline", "/timeline", "/timeline", GetTimeline, `FROM "posts"`},
+		{"followers", "/users/:id/followers", "/users/1/followers", GetUserFollowers, `FROM "user_followers"`},
+		{"following", "/users/:id/following", "/users/1/following", GetUserFollowing, `FROM "user_followers"`},
+		{"friends", "/users/:id/friends", "/users/1/friends", GetUserFriends, `FROM "user_friends"`},
+		{"comments", "/posts/:postID/comments", "/posts/5/comments", GetPostComments, `FROM "post_comments"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements := useTestDB(t)
+			if status, _ := send(t, fiber.MethodGet, tt.route, tt.target, "", tt.handler); status != fiber.StatusOK {
+				t.Fatalf("status = %d, want %d", status, fiber.StatusOK)
+			}
+
+			var listing string
+			for _, statement := range *statements {
+				if strings.HasPrefix(statement, "SELECT * "+tt.table) {
+					listing = statement
+				}
+			}
+			if listing == "" {
+				t.Fatalf("no query %s in %q", tt.table, *statements)
+			}
+			hidden := `SELECT "id" FROM "users" WHERE status = 1 OR deleted_at IS NOT NULL`
+			if !strings.Contains(listing, hidden) {
+				t.Errorf("query %s does not leave out deleted users", listing)
+			}
+			if strings.Contains(listing, `"users"."deleted_at" IS NULL`) {
+				t.Errorf("query %s only hides users that were not deleted", listing)
+			}
+		})
+	}
+}
+
+func TestFindVisiblePostOfDeletedUser(t *testing.T) {
+	useTestDB(t)
+	db := database.DB.Db
+	if _, err := findVisiblePost(db, 2, 5); err != nil {
+		t.Fatalf("findVisiblePost() error = %v", err)
+	}
+
+	// The author is soft-deleted, so preloading them finds nothing
+	db.Callback().Query().Before("gorm:preload").Register("test:deleted", func(tx *gorm.DB) {
+		if users, ok := tx.Statement.Dest.(*[]*models.User); ok {
+			*users = nil
+		}
+	})
+	if _, err := findVisiblePost(db, 2, 5); err != gorm.ErrRecordNotFound {
+		t.Errorf("findVisiblePost() error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
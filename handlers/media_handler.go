@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/jobs"
+	"github.com/coaltail/GoOrders/media"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Uploads larger than this are rejected, unless MEDIA_MAX_SIZE sets a different limit in bytes
+const defaultMaxMediaSize = 10 << 20
+
+// The content types that may be uploaded. They are detected from the file contents, the type and file name sent
+// by the client are ignored.
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"video/mp4":  true,
+	"video/webm": true,
+}
+
+func maxMediaSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("MEDIA_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultMaxMediaSize
+	}
+	return size
+}
+
+// Returns the request body limit the app needs to accept media uploads, which is the largest allowed file plus
+// room for the rest of the multipart form.
+func MediaBodyLimit() int {
+	return int(maxMediaSize()) + 1<<20
+}
+
+// Uploads a file from the "file" form field. The returned attachment can then be sent along with a post or a message.
+func UploadMedia(c *fiber.Ctx) error {
+	db := database.DB.Db
+	ownerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "A file is required", err)
+	}
+	if fileHeader.Size > maxMediaSize() {
+		return handleError(c, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("Files may be at most %d bytes", maxMediaSize()), nil)
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not read file", err)
+	}
+	defer file.Close()
+
+	detected, err := mimetype.DetectReader(file)
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not read file", err)
+	}
+	contentType := detected.String()
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if !allowedMediaTypes[contentType] {
+		return handleError(c, fiber.StatusUnsupportedMediaType, "Unsupported file type "+contentType, nil)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not read file", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not read file", err)
+	}
+	// Photos often carry the location they were taken at, so metadata is removed before anything is stored
+	data, orientation, err := media.StripMetadata(contentType, data)
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not read image", err)
+	}
+
+	attachment := models.Attachment{
+		OwnerID:     ownerID,
+		StorageKey:  newStorageKey("attachments", detected.Extension()),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		// Formats the standard library cannot decode, like WebP, are stored without dimensions
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			attachment.Width, attachment.Height = media.OrientedSize(config.Width, config.Height, orientation)
+		}
+	}
+
+	if err := storage.Store.Put(c.Context(), attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not store file", err)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attachment).Error; err != nil {
+			return err
+		}
+		if !slices.Contains(models.ThumbnailContentTypes, contentType) {
+			return nil
+		}
+		return jobs.EnqueueGenerateVariants(tx, attachment.ID)
+	})
+	if err != nil {
+		storage.Store.Delete(c.Context(), attachment.StorageKey)
+		return handleError(c, fiber.StatusInternalServerError, "Could not store file", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(newAttachmentResponse(attachment))
+}
+
+// Streams the contents of an attachment to anyone who can see what it is attached to. With :variant set, one of
+// its resized versions is sent instead.
+func GetMedia(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	attachmentID, _ := strconv.Atoi(c.Params("mediaID"))
+	attachment, err := findVisibleAttachment(db, viewerID, uint(attachmentID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Media not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve media", err)
+	}
+
+	key, contentType, size := attachment.StorageKey, attachment.ContentType, attachment.Size
+	if name := c.Params("variant"); name != "" {
+		var variant models.AttachmentVariant
+		err := db.Where("attachment_id = ? AND name = ?", attachment.ID, name).First(&variant).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return handleError(c, fiber.StatusNotFound, "Media variant not found", err)
+		}
+		if err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not retrieve media", err)
+		}
+		key, contentType, size = variant.StorageKey, variant.ContentType, variant.Size
+	}
+
+	body, err := storage.Store.Get(c.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Media not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve media", err)
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.SendStream(body, int(size))
+}
+
+// Deletes an attachment of the requesting user, removing it from whatever it was attached to.
+func DeleteMedia(c *fiber.Ctx) error {
+	db := database.DB.Db
+	ownerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	attachmentID, _ := strconv.Atoi(c.Params("mediaID"))
+	var attachment models.Attachment
+	err = db.Preload("Variants").Where("id = ? AND owner_id = ?", attachmentID, ownerID).First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Media not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find media", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("attachment_id = ?", attachment.ID).Delete(&models.AttachmentVariant{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&attachment).Error
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete media", err)
+	}
+	for _, variant := range attachment.Variants {
+		if err := storage.Store.Delete(c.Context(), variant.StorageKey); err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not delete media", err)
+		}
+	}
+	if err := storage.Store.Delete(c.Context(), attachment.StorageKey); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete media", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Media deleted successfully.",
+	})
+}
+
+// Loads an attachment the viewer is allowed to see. Attachments hidden from the viewer are reported as
+// gorm.ErrRecordNotFound, like hidden posts.
+func findVisibleAttachment(db *gorm.DB, viewerID uint, attachmentID uint) (models.Attachment, error) {
+	var attachment models.Attachment
+	if err := db.First(&attachment, attachmentID).Error; err != nil {
+		return attachment, err
+	}
+	if attachment.OwnerID == viewerID {
+		return attachment, nil
+	}
+
+	switch {
+	case attachment.PostID != nil:
+		_, err := findVisiblePost(db, viewerID, *attachment.PostID)
+		return attachment, err
+	case attachment.MessageID != nil:
+		var message models.Message
+		err := db.Where("id = ? AND (message_sender_id = ? OR message_recipient_id = ?)", *attachment.MessageID, viewerID, viewerID).
+			First(&message).Error
+		return attachment, err
+	case attachment.GroupMessageID != nil:
+		var members int64
+		err := db.Model(&models.GroupMember{}).
+			Where("user_id = ? AND group_id = (SELECT group_id FROM group_messages WHERE id = ? AND deleted_at IS NULL)", viewerID, *attachment.GroupMessageID).
+			Count(&members).Error
+		if err == nil && members == 0 {
+			err = gorm.ErrRecordNotFound
+		}
+		return attachment, err
+	default:
+		return attachment, gorm.ErrRecordNotFound
+	}
+}
+
+// Returns a new unique storage key below prefix, sharded by month so that no directory grows without bounds.
+func newStorageKey(prefix string, extension string) string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	return fmt.Sprintf("%s/%s/%s%s", prefix, time.Now().UTC().Format("2006/01"), hex.EncodeToString(random), extension)
+}
+
+func newAttachmentResponse(attachment models.Attachment) models.AttachmentResponse {
+	return models.AttachmentResponse{
+		ID:          attachment.ID,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		Width:       attachment.Width,
+		Height:      attachment.Height,
+		URL:         fmt.Sprintf("/media/%d", attachment.ID),
+		Variants:    newAttachmentVariantResponses(attachment),
+	}
+}
+
+// Returns the variants of an attachment, smallest first. Images that were not processed yet have none.
+func newAttachmentVariantResponses(attachment models.Attachment) []models.AttachmentVariantResponse {
+	variantResponses := make([]models.AttachmentVariantResponse, 0, len(attachment.Variants))
+	for _, variant := range attachment.Variants {
+		variantResponses = append(variantResponses, models.AttachmentVariantResponse{
+			Name:   variant.Name,
+			Width:  variant.Width,
+			Height: variant.Height,
+			URL:    fmt.Sprintf("/media/%d/%s", attachment.ID, variant.Name),
+		})
+	}
+	sort.Slice(variantResponses, func(i, j int) bool {
+		return variantResponses[i].Width*variantResponses[i].Height < variantResponses[j].Width*variantResponses[j].Height
+	})
+	return variantResponses
+}
+
+func newAttachmentResponses(attachments []models.Attachment) []models.AttachmentResponse {
+	attachmentResponses := make([]models.AttachmentResponse, 0, len(attachments))
+	for _, attachment := range attachments {
+		attachmentResponses = append(attachmentResponses, newAttachmentResponse(attachment))
+	}
+	return attachmentResponses
+}
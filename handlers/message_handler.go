@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/events"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func SendMessage(c *fiber.Ctx) error {
+	db := database.DB.Db
+	recipientID, _ := strconv.Atoi(c.Params("userID"))
+	senderID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	if senderID == uint(recipientID) {
+		return handleError(c, fiber.StatusBadRequest, "You cannot message yourself.", fiber.ErrBadRequest)
+	}
+
+	var request models.MessageRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	var recipient models.User
+	err = db.Scopes(models.ActiveUsers).First(&recipient, recipientID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Recipient not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find recipient", err)
+	}
+
+	blocked, err := models.IsBlocked(db, senderID, recipient.ID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not send message", err)
+	}
+	if blocked {
+		return handleError(c, fiber.StatusForbidden, "You cannot message this user.", nil)
+	}
+
+	message := models.Message{
+		MessageSenderID:    senderID,
+		MessageRecipientID: recipient.ID,
+		Message:            request.Message,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if err := models.ClaimAttachments(tx, senderID, request.AttachmentIDs, models.AttachmentMessageColumn, message.ID); err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.MessageSent{MessageID: message.ID, SenderID: senderID, RecipientID: recipient.ID}); err != nil {
+			return err
+		}
+		return notifications.NotifyUser(tx, recipient.ID, senderID, models.NotificationMessage)
+	})
+	if errors.Is(err, models.ErrInvalidAttachments) {
+		return handleError(c, fiber.StatusBadRequest, "Invalid attachments", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not send message", err)
+	}
+	if err := db.Preload("Variants").Where("message_id = ?", message.ID).Order("id").Find(&message.Attachments).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not send message", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(newMessageResponse(message))
+}
+
+// Returns the messages exchanged between the requesting user and :userID, newest first.
+func GetConversation(c *fiber.Ctx) error {
+	db := database.DB.Db
+	otherID, _ := strconv.Atoi(c.Params("userID"))
+	limit, offset := getPagination(c)
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var messages []models.Message
+	err = db.Preload("Attachments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Attachments.Variants").Where("(message_sender_id = ? AND message_recipient_id = ?) OR (message_sender_id = ? AND message_recipient_id = ?)", userID, otherID, otherID, userID).
+		Order("created_at DESC").Limit(limit).Offset(offset).Find(&messages).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve messages", err)
+	}
+
+	messageResponses := make([]models.MessageResponse, 0, len(messages))
+	for _, message := range messages {
+		messageResponses = append(messageResponses, newMessageResponse(message))
+	}
+	return c.JSON(fiber.Map{
+		"messages": messageResponses,
+	})
+}
+
+func newMessageResponse(message models.Message) models.MessageResponse {
+	return models.MessageResponse{
+		ID:                 message.ID,
+		MessageSenderID:    message.MessageSenderID,
+		MessageRecipientID: message.MessageRecipientID,
+		Message:            message.Message,
+		CreatedAt:          message.CreatedAt,
+		Attachments:        newAttachmentResponses(message.Attachments),
+	}
+}
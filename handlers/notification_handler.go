@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// How many actors are shown for each group of notifications
+const maxGroupActors = 3
+
+// Notifications of the same type about the same subject are listed as one group, with read and unread ones kept
+// apart. Notifications from blocked users are left out.
+const notificationGroupsQuery = `
+SELECT max(id) AS id, type, subject_type, subject_id, read_at IS NULL AS unread,
+	count(*) AS count, count(DISTINCT actor_id) AS actor_count, max(created_at) AS latest_at,
+	array_to_string((array_agg(actor_id ORDER BY created_at DESC))[1:20], ',') AS actor_ids
+FROM notifications
+WHERE user_id = ? AND actor_id NOT IN (?)
+GROUP BY type, subject_type, subject_id, read_at IS NULL
+ORDER BY latest_at DESC
+LIMIT ? OFFSET ?`
+
+type notificationGroup struct {
+	ID          uint
+	Type        string
+	SubjectType string
+	SubjectID   uint
+	Unread      bool
+	Count       int64
+	ActorCount  int64
+	LatestAt    time.Time
+	ActorIDs    string
+}
+
+// Returns the notifications of the requesting user grouped by type and subject, newest first.
+func GetNotifications(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var groups []notificationGroup
+	err = db.Raw(notificationGroupsQuery, userID, models.HiddenUserIDs(db, userID), limit, offset).Scan(&groups).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve notifications", err)
+	}
+
+	// The most recent distinct actors of every group, and all of them to load at once
+	groupActorIDs := make([][]uint, len(groups))
+	var actorIDs []uint
+	for i, group := range groups {
+		seen := map[uint]bool{}
+		for _, value := range strings.Split(group.ActorIDs, ",") {
+			actorID, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || seen[uint(actorID)] {
+				continue
+			}
+			seen[uint(actorID)] = true
+			groupActorIDs[i] = append(groupActorIDs[i], uint(actorID))
+			actorIDs = append(actorIDs, uint(actorID))
+			if len(groupActorIDs[i]) == maxGroupActors {
+				break
+			}
+		}
+	}
+
+	var actors []models.User
+	if len(actorIDs) > 0 {
+		if err := db.Where("id IN ?", actorIDs).Find(&actors).Error; err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not retrieve notifications", err)
+		}
+	}
+	actorProfiles, err := projectUserProfiles(db, userID, actors)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve notifications", err)
+	}
+	profiles := map[uint]models.UserProfile{}
+	for _, profile := range actorProfiles {
+		profiles[profile.ID] = profile
+	}
+
+	notificationResponses := make([]models.NotificationGroupResponse, 0, len(groups))
+	for i, group := range groups {
+		groupActors := []models.UserProfile{}
+		var names []string
+		for _, actorID := range groupActorIDs[i] {
+			// Actors whose accounts are gone are still counted, just not shown
+			if profile, ok := profiles[actorID]; ok {
+				groupActors = append(groupActors, profile)
+				names = append(names, strings.TrimSpace(profile.FirstName+" "+profile.LastName))
+			}
+		}
+		notificationResponses = append(notificationResponses, models.NotificationGroupResponse{
+			ID:          group.ID,
+			Type:        group.Type,
+			SubjectType: group.SubjectType,
+			SubjectID:   group.SubjectID,
+			Summary:     notifications.Summarize(group.Type, names, group.ActorCount),
+			Actors:      groupActors,
+			ActorCount:  group.ActorCount,
+			Count:       group.Count,
+			Unread:      group.Unread,
+			LatestAt:    group.LatestAt,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"notifications": notificationResponses,
+	})
+}
+
+func GetUnreadNotificationCount(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var count int64
+	err = db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL AND actor_id NOT IN (?)", userID, models.HiddenUserIDs(db, userID)).
+		Count(&count).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not count notifications", err)
+	}
+	return c.JSON(fiber.Map{
+		"count": count,
+	})
+}
+
+// Marks a notification read, together with the unread notifications grouped with it.
+func MarkNotificationRead(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	notificationID, _ := strconv.Atoi(c.Params("notificationID"))
+	var notification models.Notification
+	err = db.Where("id = ? AND user_id = ?", notificationID, userID).First(&notification).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Notification not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find notification", err)
+	}
+
+	err = db.Model(&models.Notification{}).
+		Where("user_id = ? AND type = ? AND subject_type = ? AND subject_id = ? AND read_at IS NULL",
+			userID, notification.Type, notification.SubjectType, notification.SubjectID).
+		Update("read_at", time.Now()).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not mark notification read", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Notification marked as read.",
+	})
+}
+
+func MarkAllNotificationsRead(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	result := db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Update("read_at", time.Now())
+	if result.Error != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not mark notifications read", result.Error)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "All notifications marked as read.",
+		"count":  result.RowsAffected,
+	})
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/events"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func CreatePost(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var request models.PostRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	// Users post on their own wall, so the wall owner and the sender are the same user
+	post := models.Post{
+		UserID:   uint(id),
+		SenderID: uint(id),
+		Message:  request.Message,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		if err := models.ClaimAttachments(tx, post.SenderID, request.AttachmentIDs, models.AttachmentPostColumn, post.ID); err != nil {
+			return err
+		}
+		if err := syncPostTags(tx, post); err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.PostCreated{PostID: post.ID, UserID: post.UserID, SenderID: post.SenderID}); err != nil {
+			return err
+		}
+		return models.IncrementUserCounter(tx, post.UserID, models.PostCountColumn, 1)
+	})
+	if errors.Is(err, models.ErrInvalidAttachments) {
+		return handleError(c, fiber.StatusBadRequest, "Invalid attachments", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create post", err)
+	}
+	publishTimelineEvent(db, post)
+
+	postResponses, err := buildPostResponses(db, uint(id), []models.Post{post})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create post", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(postResponses[0])
+}
+
+func GetUserPosts(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var owner models.User
+	if err := db.Scopes(models.ActiveUsers).First(&owner, id).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	blocked, err := models.IsBlocked(db, viewerID, owner.ID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve posts", err)
+	}
+	if blocked {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", nil)
+	}
+	allowed, err := canViewPosts(db, viewerID, owner)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve posts", err)
+	}
+	if !allowed {
+		return handleError(c, fiber.StatusForbidden, "This account is private.", nil)
+	}
+
+	var posts []models.Post
+	err = db.Where("user_id = ? AND sender_id NOT IN (?)", owner.ID, models.DeactivatedUserIDs(db)).Order("created_at DESC").Limit(limit).Offset(offset).Find(&posts).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve posts", err)
+	}
+
+	postResponses, err := buildPostResponses(db, viewerID, posts)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve posts", err)
+	}
+	return c.JSON(fiber.Map{
+		"posts": postResponses,
+	})
+}
+
+// Returns the newest posts of the requesting user and everyone they follow, without muted, blocked or deactivated
+// users.
+func GetTimeline(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	// Pending follows are left out, so private accounts only show up once they approved the viewer
+	following := models.FollowingIDs(db, viewerID)
+	muted := models.MutedUserIDs(db, viewerID)
+	hidden := models.HiddenUserIDs(db, viewerID)
+
+	var posts []models.Post
+	err = db.Where("user_id = ? OR user_id IN (?)", viewerID, following).
+		Where("user_id NOT IN (?) AND sender_id NOT IN (?)", muted, muted).
+		Where("user_id NOT IN (?) AND sender_id NOT IN (?)", hidden, hidden).
+		Order("created_at DESC").Limit(limit).Offset(offset).Find(&posts).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve timeline", err)
+	}
+
+	postResponses, err := buildPostResponses(db, viewerID, posts)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve timeline", err)
+	}
+	return c.JSON(fiber.Map{
+		"posts": postResponses,
+	})
+}
+
+// Edits the text of a post. Only its author may do so, and only users mentioned for the first time are notified.
+func UpdatePost(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+	postID, _ := strconv.Atoi(c.Params("postID"))
+
+	var request models.MessageUpdateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	var post models.Post
+	err := db.Where("id = ? AND sender_id = ?", postID, id).First(&post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Post not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find post", err)
+	}
+
+	post.Message = request.Message
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&post).Update("message", post.Message).Error; err != nil {
+			return err
+		}
+		return syncPostTags(tx, post)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update post", err)
+	}
+
+	postResponses, err := buildPostResponses(db, uint(id), []models.Post{post})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update post", err)
+	}
+	return c.JSON(postResponses[0])
+}
+
+func DeletePost(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+	postID, _ := strconv.Atoi(c.Params("postID"))
+
+	// Both the owner of the wall and the author of the post may delete it
+	var post models.Post
+	err := db.Where("id = ? AND (user_id = ? OR sender_id = ?)", postID, id, id).First(&post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Post not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find post", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&post)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return models.IncrementUserCounter(tx, post.UserID, models.PostCountColumn, -1)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete post", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Post deleted successfully.",
+	})
+}
+
+// Loads a post the viewer is allowed to see. Posts hidden from the viewer are reported as gorm.ErrRecordNotFound,
+// so that their existence is not revealed.
+func findVisiblePost(db *gorm.DB, viewerID uint, postID uint) (models.Post, error) {
+	var post models.Post
+	if err := db.Preload("User").Preload("Sender").First(&post, postID).Error; err != nil {
+		return post, err
+	}
+	// Users who deleted their account are not preloaded, their posts stay hidden until the purge job removes them
+	if post.User.ID == 0 || post.Sender.ID == 0 {
+		return post, gorm.ErrRecordNotFound
+	}
+	if post.Sender.Status == models.UserStatusDeactivated && post.SenderID != viewerID {
+		return post, gorm.ErrRecordNotFound
+	}
+	allowed, err := canViewPosts(db, viewerID, post.User)
+	if err != nil {
+		return post, err
+	}
+	if !allowed {
+		return post, gorm.ErrRecordNotFound
+	}
+	return post, nil
+}
+
+// Updates the hashtags and mentions of a post and notifies the newly mentioned users, as long as they can see it.
+func syncPostTags(tx *gorm.DB, post models.Post) error {
+	mentionedIDs, err := models.SyncPostTags(tx, post)
+	if err != nil || len(mentionedIDs) == 0 {
+		return err
+	}
+
+	var owner models.User
+	if err := tx.First(&owner, post.UserID).Error; err != nil {
+		return err
+	}
+	var recipientIDs []uint
+	for _, mentionedID := range mentionedIDs {
+		allowed, err := canViewPosts(tx, mentionedID, owner)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			recipientIDs = append(recipientIDs, mentionedID)
+		}
+	}
+	return notifications.Notify(tx, recipientIDs, post.SenderID, models.NotificationMention, models.SubjectPost, post.ID)
+}
+
+type reactionCount struct {
+	PostID uint
+	Kind   string
+	Count  int64
+}
+
+// Turns posts into responses, with the attachments, the reaction summary of every post and the viewer's own reactions
+// loaded for the whole page at once.
+func buildPostResponses(db *gorm.DB, viewerID uint, posts []models.Post) ([]models.PostResponse, error) {
+	postIDs := make([]uint, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+
+	reactionCounts := map[uint]map[string]int64{}
+	myReactions := map[uint]string{}
+	attachments := map[uint][]models.Attachment{}
+	if len(postIDs) > 0 {
+		var postAttachments []models.Attachment
+		if err := db.Preload("Variants").Where("post_id IN ?", postIDs).Order("id").Find(&postAttachments).Error; err != nil {
+			return nil, err
+		}
+		for _, attachment := range postAttachments {
+			attachments[*attachment.PostID] = append(attachments[*attachment.PostID], attachment)
+		}
+
+		var counts []reactionCount
+		err := db.Model(&models.PostReaction{}).Select("post_id, kind, count(*) AS count").
+			Where("post_id IN ?", postIDs).Group("post_id, kind").Scan(&counts).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, count := range counts {
+			if reactionCounts[count.PostID] == nil {
+				reactionCounts[count.PostID] = map[string]int64{}
+			}
+			reactionCounts[count.PostID][count.Kind] = count.Count
+		}
+
+		var reactions []models.PostReaction
+		if err := db.Where("post_id IN ? AND user_id = ?", postIDs, viewerID).Find(&reactions).Error; err != nil {
+			return nil, err
+		}
+		for _, reaction := range reactions {
+			myReactions[reaction.PostID] = reaction.Kind
+		}
+	}
+
+	postResponses := make([]models.PostResponse, 0, len(posts))
+	for _, post := range posts {
+		reactions := reactionCounts[post.ID]
+		if reactions == nil {
+			reactions = map[string]int64{}
+		}
+		postResponses = append(postResponses, models.PostResponse{
+			ID:         post.ID,
+			UserID:     post.UserID,
+			SenderID:   post.SenderID,
+			Message:    post.Message,
+			CreatedAt:  post.CreatedAt,
+			UpdatedAt:  post.UpdatedAt,
+			Reactions:  reactions,
+			MyReaction: myReactions[post.ID],
+
+			CommentCount: post.CommentCount,
+			Attachments:  newAttachmentResponses(attachments[post.ID]),
+		})
+	}
+	return postResponses, nil
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/media"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofiber/fiber/v2"
+)
+
+func UploadAvatar(c *fiber.Ctx) error {
+	return uploadProfileImage(c, models.AvatarKeyColumn, "avatars", media.AvatarSpec)
+}
+
+func UploadCover(c *fiber.Ctx) error {
+	return uploadProfileImage(c, models.CoverKeyColumn, "covers", media.CoverSpec)
+}
+
+func DeleteAvatar(c *fiber.Ctx) error {
+	return deleteProfileImage(c, models.AvatarKeyColumn)
+}
+
+func DeleteCover(c *fiber.Ctx) error {
+	return deleteProfileImage(c, models.CoverKeyColumn)
+}
+
+// Replaces one of the profile images of the user with the image in the "file" form field, cropped and resized to
+// the spec. The previous image is removed from storage once the new one is in place.
+func uploadProfileImage(c *fiber.Ctx, column string, prefix string, spec media.ProfileImageSpec) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "A file is required", err)
+	}
+	if fileHeader.Size > maxMediaSize() {
+		return handleError(c, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("Files may be at most %d bytes", maxMediaSize()), nil)
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not read file", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not read file", err)
+	}
+
+	contentType := mimetype.Detect(data).String()
+	processed, contentType, extension, err := media.ProcessProfileImage(contentType, data, spec)
+	if errors.Is(err, media.ErrUnsupportedImage) {
+		return handleError(c, fiber.StatusUnsupportedMediaType, "Profile images must be JPEG, PNG or GIF files", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid image", err)
+	}
+
+	key := newStorageKey(prefix, extension)
+	if err := storage.Store.Put(c.Context(), key, bytes.NewReader(processed), int64(len(processed)), contentType); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not store image", err)
+	}
+	previous := profileImageKey(user, column)
+	if err := db.Model(&user).UpdateColumn(column, key).Error; err != nil {
+		storage.Store.Delete(c.Context(), key)
+		return handleError(c, fiber.StatusInternalServerError, "Could not store image", err)
+	}
+	if previous != "" {
+		storage.Store.Delete(c.Context(), previous)
+	}
+	setProfileImageKey(&user, column, key)
+
+	userProfile, err := projectUserProfile(db, user.ID, user)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+	return c.JSON(userProfile)
+}
+
+func deleteProfileImage(c *fiber.Ctx, column string) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	previous := profileImageKey(user, column)
+	if previous == "" {
+		return handleError(c, fiber.StatusNotFound, "There is no image to delete", nil)
+	}
+
+	if err := db.Model(&user).UpdateColumn(column, "").Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete image", err)
+	}
+	if err := storage.Store.Delete(c.Context(), previous); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete image", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Image deleted successfully.",
+	})
+}
+
+func profileImageKey(user models.User, column string) string {
+	if column == models.AvatarKeyColumn {
+		return user.AvatarKey
+	}
+	return user.CoverKey
+}
+
+func setProfileImageKey(user *models.User, column string, key string) {
+	if column == models.AvatarKeyColumn {
+		user.AvatarKey = key
+	} else {
+		user.CoverKey = key
+	}
+}
+
+// Serves a stored file through a signed URL, see storage.SignedURL. The signature takes the place of the JWT, so
+// that the URLs work in image tags.
+func ServeSignedFile(c *fiber.Ctx) error {
+	key := c.Params("*")
+	if !storage.VerifySignedURL(key, c.Query("expires"), c.Query("signature")) {
+		return handleError(c, fiber.StatusForbidden, "Invalid or expired link", nil)
+	}
+
+	body, err := storage.Store.Get(c.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return handleError(c, fiber.StatusNotFound, "File not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve file", err)
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		c.Set(fiber.HeaderContentType, contentType)
+	}
+	if strings.HasPrefix(key, "exports/") {
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="data-export.zip"`)
+	}
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+	return c.SendStream(body)
+}
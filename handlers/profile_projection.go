@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+// The viewerRelations type holds how the viewer is connected to a set of users, so that privacy settings can be
+// checked for a whole list with a fixed number of queries instead of several per user.
+type viewerRelations struct {
+	viewerID  uint
+	following map[uint]bool
+	friends   map[uint]bool
+}
+
+func loadViewerRelations(db *gorm.DB, viewerID uint, userIDs []uint) (viewerRelations, error) {
+	relations := viewerRelations{
+		viewerID:  viewerID,
+		following: map[uint]bool{},
+		friends:   map[uint]bool{},
+	}
+	if len(userIDs) == 0 {
+		return relations, nil
+	}
+
+	var following []models.UserFollower
+	if err := db.Where("source_id = ? AND target_id IN ? AND type = ?", viewerID, userIDs, models.FollowTypeActive).Find(&following).Error; err != nil {
+		return relations, err
+	}
+	for _, follow := range following {
+		relations.following[follow.TargetID] = true
+	}
+
+	var friends []models.UserFriend
+	err := db.Where("((source_id = ? AND target_id IN ?) OR (target_id = ? AND source_id IN ?)) AND status = ?", viewerID, userIDs, viewerID, userIDs, models.FriendStatusAccepted).
+		Find(&friends).Error
+	if err != nil {
+		return relations, err
+	}
+	for _, friend := range friends {
+		if friend.SourceID == viewerID {
+			relations.friends[friend.TargetID] = true
+		} else {
+			relations.friends[friend.SourceID] = true
+		}
+	}
+	return relations, nil
+}
+
+// Reports whether the viewer may see a field of the owner's profile with the given visibility level.
+func (r viewerRelations) canSee(ownerID uint, visibility int) bool {
+	if r.viewerID == ownerID {
+		return true
+	}
+	switch visibility {
+	case models.VisibilityPublic:
+		return true
+	case models.VisibilityFollowers:
+		return r.following[ownerID] || r.friends[ownerID]
+	case models.VisibilityFriends:
+		return r.friends[ownerID]
+	default:
+		return false
+	}
+}
+
+func (r viewerRelations) project(user models.User) models.UserProfile {
+	settings := user.PrivacySettings()
+	profile := models.UserProfile{
+		ID:         user.ID,
+		FirstName:  user.FirstName,
+		MiddleName: user.MiddleName,
+		LastName:   user.LastName,
+		IsPrivate:  user.IsPrivate,
+		Username:   user.Username,
+		AvatarURL:  storage.SignedURL(user.AvatarKey),
+		CoverURL:   storage.SignedURL(user.CoverKey),
+
+		FollowerCount:  user.FollowerCount,
+		FollowingCount: user.FollowingCount,
+		FriendCount:    user.FriendCount,
+		PostCount:      user.PostCount,
+	}
+	if r.canSee(user.ID, settings.EmailVisibility) {
+		profile.Email = user.Email
+	}
+	if r.canSee(user.ID, settings.MobileVisibility) {
+		profile.Mobile = user.Mobile
+	}
+	if r.canSee(user.ID, settings.IntroVisibility) {
+		profile.Intro = user.Intro
+	}
+	return profile
+}
+
+// Reports whether the viewer may read the follower and friend lists of the owner.
+func canViewConnections(db *gorm.DB, viewerID uint, owner models.User) (bool, error) {
+	blocked, err := models.IsBlocked(db, viewerID, owner.ID)
+	if err != nil || blocked {
+		return false, err
+	}
+	relations, err := loadViewerRelations(db, viewerID, []uint{owner.ID})
+	if err != nil {
+		return false, err
+	}
+	return relations.canSee(owner.ID, owner.PrivacySettings().ConnectionsVisibility), nil
+}
+
+// Reports whether the viewer may read the posts of the owner. Private accounts only show them to approved followers,
+// deactivated accounts to no one.
+func canViewPosts(db *gorm.DB, viewerID uint, owner models.User) (bool, error) {
+	if viewerID == owner.ID {
+		return true, nil
+	}
+	if owner.Status == models.UserStatusDeactivated {
+		return false, nil
+	}
+	blocked, err := models.IsBlocked(db, viewerID, owner.ID)
+	if err != nil || blocked {
+		return false, err
+	}
+	if !owner.IsPrivate {
+		return true, nil
+	}
+
+	var follows int64
+	err = db.Model(&models.UserFollower{}).
+		Where("source_id = ? AND target_id = ? AND type = ?", viewerID, owner.ID, models.FollowTypeActive).
+		Count(&follows).Error
+	return follows > 0, err
+}
+
+// The projectUserProfiles function turns users into the profiles the viewer is allowed to see. Every handler that
+// renders a UserProfile has to go through it, so that privacy settings are applied in one place.
+func projectUserProfiles(db *gorm.DB, viewerID uint, users []models.User) ([]models.UserProfile, error) {
+	userIDs := make([]uint, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+
+	relations, err := loadViewerRelations(db, viewerID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	userProfiles := make([]models.UserProfile, 0, len(users))
+	for _, user := range users {
+		userProfiles = append(userProfiles, relations.project(user))
+	}
+	return userProfiles, nil
+}
+
+func projectUserProfile(db *gorm.DB, viewerID uint, user models.User) (models.UserProfile, error) {
+	userProfiles, err := projectUserProfiles(db, viewerID, []models.User{user})
+	if err != nil {
+		return models.UserProfile{}, err
+	}
+	return userProfiles[0], nil
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func GetReactionKinds(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"kinds": models.AllowedReactionKinds(),
+	})
+}
+
+// Reacts to a post with the given kind. Users have at most one reaction per post, reacting again replaces it.
+func ReactToPost(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.ReactionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	request.Kind = strings.ToLower(strings.TrimSpace(request.Kind))
+	if !models.IsAllowedReactionKind(request.Kind) {
+		return handleError(c, fiber.StatusBadRequest, "Unknown reaction kind", nil)
+	}
+
+	postID, _ := strconv.Atoi(c.Params("postID"))
+	post, err := findVisiblePost(db, viewerID, uint(postID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Post not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find post", err)
+	}
+
+	reaction := models.PostReaction{PostID: post.ID, UserID: viewerID}
+	err = db.Where(reaction).Assign(models.PostReaction{Kind: request.Kind}).FirstOrCreate(&reaction).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not react to post", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Reacted successfully",
+		"kind":   reaction.Kind,
+	})
+}
+
+func UnreactToPost(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	result := db.Unscoped().Where("post_id = ? AND user_id = ?", c.Params("postID"), viewerID).Delete(&models.PostReaction{})
+	if result.Error != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete record", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return handleError(c, fiber.StatusNotFound, "Could not find record.", nil)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Record deleted succesfully.",
+	})
+}
+
+// Returns the users who reacted to a post, newest first, optionally only those who reacted with "kind".
+func GetPostReactions(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	postID, _ := strconv.Atoi(c.Params("postID"))
+	post, err := findVisiblePost(db, viewerID, uint(postID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Post not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find post", err)
+	}
+
+	query := db.Preload("User").Where("post_id = ?", post.ID).Where("user_id NOT IN (?)", models.HiddenUserIDs(db, viewerID))
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", strings.ToLower(kind))
+	}
+
+	var reactions []models.PostReaction
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&reactions).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve reactions", err)
+	}
+
+	users := make([]models.User, 0, len(reactions))
+	for _, reaction := range reactions {
+		users = append(users, reaction.User)
+	}
+	userProfiles, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve reactions", err)
+	}
+
+	reactors := make([]models.ReactorResponse, 0, len(reactions))
+	for i, reaction := range reactions {
+		reactors = append(reactors, models.ReactorResponse{
+			Profile:   userProfiles[i],
+			Kind:      reaction.Kind,
+			ReactedAt: reaction.CreatedAt,
+		})
+	}
+
+	postResponses, err := buildPostResponses(db, viewerID, []models.Post{post})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve reactions", err)
+	}
+	return c.JSON(fiber.Map{
+		"summary":  postResponses[0].Reactions,
+		"reactors": reactors,
+	})
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/stream"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Returns the follow, friend and block state between the requesting user and :id in a single call.
+func GetRelationshipStatus(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	targetID, _ := strconv.Atoi(c.Params("id"))
+
+	var target models.User
+	err = db.Scopes(models.ActiveUsers).First(&target, targetID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Target user not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+
+	relationship, err := loadRelationship(db, viewerID, target.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Target user not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve relationship", err)
+	}
+	return c.JSON(relationship)
+}
+
+// Loads the relationship between two users. When the target blocked the source, gorm.ErrRecordNotFound is returned,
+// blocked users must not learn anything about each other.
+func loadRelationship(db *gorm.DB, sourceID uint, targetID uint) (models.Relationship, error) {
+	relationship := models.Relationship{UserID: targetID}
+
+	var follows []models.UserFollower
+	err := db.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+		Find(&follows).Error
+	if err != nil {
+		return relationship, err
+	}
+	for _, follow := range follows {
+		switch {
+		case follow.SourceID == sourceID && follow.Type == models.FollowTypePending:
+			relationship.FollowRequestSent = true
+		case follow.SourceID == sourceID:
+			relationship.Following = true
+		case follow.Type == models.FollowTypePending:
+			relationship.FollowRequestReceived = true
+		default:
+			relationship.FollowedBy = true
+		}
+	}
+
+	var friends []models.UserFriend
+	err = db.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+		Find(&friends).Error
+	if err != nil {
+		return relationship, err
+	}
+	for _, friend := range friends {
+		switch {
+		case friend.Status == models.FriendStatusAccepted:
+			relationship.Friends = true
+		case friend.SourceID == sourceID:
+			relationship.FriendRequestSent = true
+		default:
+			relationship.FriendRequestReceived = true
+		}
+	}
+
+	var blocks []models.UserBlock
+	err = db.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+		Find(&blocks).Error
+	if err != nil {
+		return relationship, err
+	}
+	for _, block := range blocks {
+		switch {
+		case block.Type == models.RestrictionBlock && block.SourceID == sourceID:
+			relationship.Blocking = true
+		case block.Type == models.RestrictionBlock:
+			return models.Relationship{}, gorm.ErrRecordNotFound
+		case block.Type == models.RestrictionMute && block.SourceID == sourceID:
+			relationship.Muting = true
+		}
+	}
+
+	return relationship, nil
+}
+
+func BlockUser(c *fiber.Ctx) error {
+	return restrictUser(c, models.RestrictionBlock)
+}
+
+func UnblockUser(c *fiber.Ctx) error {
+	return removeRestriction(c, models.RestrictionBlock)
+}
+
+func GetBlockedUsers(c *fiber.Ctx) error {
+	return listRestrictedUsers(c, models.RestrictionBlock)
+}
+
+func MuteUser(c *fiber.Ctx) error {
+	return restrictUser(c, models.RestrictionMute)
+}
+
+func UnmuteUser(c *fiber.Ctx) error {
+	return removeRestriction(c, models.RestrictionMute)
+}
+
+func GetMutedUsers(c *fiber.Ctx) error {
+	return listRestrictedUsers(c, models.RestrictionMute)
+}
+
+func restrictUser(c *fiber.Ctx, restriction int) error {
+	db := database.DB.Db
+	sourceID, _ := strconv.Atoi(c.Params("id"))
+	targetID, _ := strconv.Atoi(c.Params("targetID"))
+	if sourceID == targetID {
+		return handleError(c, fiber.StatusBadRequest, "You cannot block or mute yourself.", fiber.ErrBadRequest)
+	}
+
+	var targetUser models.User
+	err := db.First(&targetUser, targetID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Target user not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+
+	userBlock := models.UserBlock{
+		SourceID: uint(sourceID),
+		TargetID: uint(targetID),
+		Type:     restriction,
+	}
+	var follows []models.UserFollower
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(userBlock).FirstOrCreate(&userBlock).Error; err != nil {
+			return err
+		}
+		if restriction != models.RestrictionBlock {
+			return nil
+		}
+
+		// Blocking removes every connection between the two users, whichever of them created it
+		err := tx.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+			Find(&follows).Error
+		if err != nil {
+			return err
+		}
+		for _, follow := range follows {
+			if err := deleteUserFollower(tx, follow); err != nil {
+				return err
+			}
+		}
+
+		var friends []models.UserFriend
+		err = tx.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+			Find(&friends).Error
+		if err != nil {
+			return err
+		}
+		for _, friend := range friends {
+			if err := deleteUserFriend(tx, friend); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not restrict user", err)
+	}
+	for _, follow := range follows {
+		publishFollowerEvent(follow, stream.FollowRemoved)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"detail": "User restricted successfully",
+	})
+}
+
+func removeRestriction(c *fiber.Ctx, restriction int) error {
+	db := database.DB.Db
+	sourceID, _ := strconv.Atoi(c.Params("id"))
+	targetID, _ := strconv.Atoi(c.Params("targetID"))
+
+	result := db.Unscoped().Where("source_id = ? AND target_id = ? AND type = ?", sourceID, targetID, restriction).
+		Delete(&models.UserBlock{})
+	if result.Error != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete record", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return handleError(c, fiber.StatusNotFound, "Could not find record.", nil)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Record deleted succesfully.",
+	})
+}
+
+func listRestrictedUsers(c *fiber.Ctx, restriction int) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var blocks []models.UserBlock
+	var users []models.User
+	if err := db.Preload("Target").Where("source_id = ? AND type = ?", id, restriction).Find(&blocks).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve users", err)
+	}
+
+	for _, block := range blocks {
+		users = append(users, block.Target)
+	}
+	userProfile, err := projectUserProfiles(db, uint(id), users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve users", err)
+	}
+	return c.JSON(fiber.Map{
+		"users": userProfile,
+	})
+}
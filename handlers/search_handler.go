@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	minSearchQueryLength = 2
+	headlineOptions      = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5"
+)
+
+// Full-text matches are ranked above trigram matches by adding 1 to their ts_rank, so fuzzy name hits only fill in
+// the results after every exact word match. Intros that are not public may not be what made a user match, otherwise
+// searching would reveal their contents.
+const userSearchQuery = `
+SELECT id, first_name, middle_name, last_name, intro, intro_visibility, avatar_key,
+	CASE WHEN search_vector @@ query THEN 1 + ts_rank(search_vector, query) ELSE similarity(search_name, ?) END AS rank,
+	ts_headline('simple', concat_ws(' ', first_name, middle_name, last_name), query, ?) AS highlight,
+	ts_headline('simple', coalesce(intro, ''), query, ?) AS intro_highlight
+FROM users, websearch_to_tsquery('simple', ?) AS query
+WHERE deleted_at IS NULL AND (
+	(search_vector @@ query AND (coalesce(intro_visibility, 0) IN (0, ?) OR to_tsvector('simple', search_name) @@ query))
+	OR search_name % ?
+) AND id NOT IN (?)
+ORDER BY rank DESC, id
+LIMIT ? OFFSET ?`
+
+const postSearchQuery = `
+SELECT id, user_id, sender_id, message, created_at,
+	ts_rank(search_vector, query) AS rank,
+	ts_headline('simple', message, query, ?) AS highlight
+FROM posts, websearch_to_tsquery('simple', ?) AS query
+WHERE deleted_at IS NULL AND search_vector @@ query AND user_id NOT IN (?) AND sender_id NOT IN (?)
+	AND (user_id = ? OR user_id IN (?) OR user_id NOT IN (SELECT id FROM users WHERE is_private))
+ORDER BY rank DESC, created_at DESC
+LIMIT ? OFFSET ?`
+
+func Search(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if len(q) < minSearchQueryLength {
+		return handleError(c, fiber.StatusBadRequest, "Search query must be at least 2 characters long", nil)
+	}
+
+	searchType := c.Query("type", "all")
+	if searchType != "all" && searchType != "users" && searchType != "posts" {
+		return handleError(c, fiber.StatusBadRequest, "Search type must be one of: all, users, posts", nil)
+	}
+
+	limit, offset := getPagination(c)
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	// Users who blocked the viewer, were blocked by them or deactivated their account never show up in the results
+	hidden := models.HiddenUserIDs(db, viewerID)
+
+	users := []models.UserSearchResult{}
+	posts := []models.PostSearchResult{}
+
+	if searchType != "posts" {
+		name := strings.ToLower(q)
+		err := db.Raw(userSearchQuery, name, headlineOptions, headlineOptions, q, models.VisibilityPublic, name, hidden, limit, offset).
+			Scan(&users).Error
+		if err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not search users", err)
+		}
+		if err := hidePrivateIntros(db, viewerID, users); err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not search users", err)
+		}
+	}
+
+	if searchType != "users" {
+		// Posts of private accounts are only found by their approved followers
+		following := models.FollowingIDs(db, viewerID)
+		err := db.Raw(postSearchQuery, headlineOptions, q, hidden, hidden, viewerID, following, limit, offset).Scan(&posts).Error
+		if err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not search posts", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"users": users,
+		"posts": posts,
+	})
+}
+
+// Clears the intro of every search result the viewer is not allowed to see according to the user's privacy settings,
+// and signs the avatar URLs.
+func hidePrivateIntros(db *gorm.DB, viewerID uint, users []models.UserSearchResult) error {
+	userIDs := make([]uint, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+	relations, err := loadViewerRelations(db, viewerID, userIDs)
+	if err != nil {
+		return err
+	}
+
+	for i, user := range users {
+		users[i].AvatarURL = storage.SignedURL(user.AvatarKey)
+		visibility := models.EffectiveVisibility(user.IntroVisibility, models.DefaultIntroVisibility)
+		if !relations.canSee(user.ID, visibility) {
+			users[i].Intro = ""
+			users[i].IntroHighlight = ""
+		}
+	}
+	return nil
+}
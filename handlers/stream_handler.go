@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/stream"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Comments are sent this often when nothing else happens, so proxies do not close idle streams
+const streamHeartbeat = 20 * time.Second
+
+// Streams notifications, follower changes and timeline updates of the requesting user as server-sent events.
+// Clients that reconnect with a Last-Event-ID header, or a lastEventId query parameter, first get the events they
+// missed, or a reset event when some of them are no longer known.
+func StreamEvents(c *fiber.Ctx) error {
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	var since uint64
+	if lastEventID != "" {
+		since, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return handleError(c, fiber.StatusBadRequest, "Invalid Last-Event-ID", err)
+		}
+	}
+
+	missed, complete, events, cancel := stream.Default.Subscribe(userID, since)
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if !complete {
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", stream.EventReset)
+		}
+		for _, event := range missed {
+			writeStreamEvent(w, event)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					// Fell too far behind, the client reconnects and resumes from the log
+					return
+				}
+				writeStreamEvent(w, event)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			// Writing fails once the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func writeStreamEvent(w *bufio.Writer, event stream.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
+
+// Tells both users of a follow that it changed. It is called after the change was committed.
+func publishFollowerEvent(follow models.UserFollower, action string) {
+	event := stream.FollowerEvent{
+		Action:     action,
+		FollowerID: follow.SourceID,
+		FolloweeID: follow.TargetID,
+	}
+	if err := stream.Default.Publish([]uint{follow.SourceID, follow.TargetID}, stream.EventFollower, event); err != nil {
+		log.Printf("Could not publish follower event: %v", err)
+	}
+}
+
+// Tells the author and their followers about a new post, leaving out followers who muted or blocked the author.
+// It is called after the post was committed.
+func publishTimelineEvent(db *gorm.DB, post models.Post) {
+	var recipientIDs []uint
+	err := db.Model(&models.UserFollower{}).
+		Where("target_id = ? AND type = ?", post.UserID, models.FollowTypeActive).
+		Where("source_id NOT IN (?)", models.BlockedUserIDs(db, post.UserID)).
+		Where("source_id NOT IN (?)", db.Model(&models.UserBlock{}).Select("source_id").Where("target_id = ? AND type = ?", post.UserID, models.RestrictionMute)).
+		Pluck("source_id", &recipientIDs).Error
+	if err != nil {
+		log.Printf("Could not publish timeline event for post %d: %v", post.ID, err)
+		return
+	}
+
+	event := stream.TimelineEvent{
+		PostID:   post.ID,
+		UserID:   post.UserID,
+		SenderID: post.SenderID,
+	}
+	if err := stream.Default.Publish(append(recipientIDs, post.UserID), stream.EventTimeline, event); err != nil {
+		log.Printf("Could not publish timeline event for post %d: %v", post.ID, err)
+	}
+}
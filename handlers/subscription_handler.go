@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func GetVAPIDPublicKey(c *fiber.Ctx) error {
+	publicKey := notifications.VAPIDPublicKey()
+	if publicKey == "" {
+		return handleError(c, fiber.StatusServiceUnavailable, "Web Push is not configured", nil)
+	}
+	return c.JSON(fiber.Map{
+		"publicKey": publicKey,
+	})
+}
+
+// Registers a browser push subscription or a webhook of the requesting user. Webhooks get a secret their payloads
+// are signed with, which is only returned here.
+func CreateSubscription(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.SubscriptionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+	if !isHTTPURL(request.Endpoint) {
+		return handleError(c, fiber.StatusBadRequest, "The endpoint must be an http or https URL", nil)
+	}
+
+	subscription := models.NotificationSubscription{
+		UserID:   userID,
+		Channel:  request.Channel,
+		Endpoint: request.Endpoint,
+	}
+	switch request.Channel {
+	case models.ChannelWebPush:
+		if notifications.VAPIDPublicKey() == "" {
+			return handleError(c, fiber.StatusServiceUnavailable, "Web Push is not configured", nil)
+		}
+		if request.Keys.P256dh == "" || request.Keys.Auth == "" {
+			return handleError(c, fiber.StatusBadRequest, "Push subscriptions need their p256dh and auth keys", nil)
+		}
+		subscription.P256dh = request.Keys.P256dh
+		subscription.Auth = request.Keys.Auth
+	case models.ChannelWebhook:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not create subscription", err)
+		}
+		subscription.Secret = hex.EncodeToString(secret)
+	}
+
+	// Browsers resubscribe with the same endpoint, which replaces the old subscription
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND channel = ? AND endpoint = ?", userID, subscription.Channel, subscription.Endpoint).
+			Delete(&models.NotificationSubscription{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&subscription).Error
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create subscription", err)
+	}
+
+	subscriptionResponse := newSubscriptionResponse(subscription)
+	subscriptionResponse.Secret = subscription.Secret
+	return c.Status(fiber.StatusCreated).JSON(subscriptionResponse)
+}
+
+func GetSubscriptions(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var subscriptions []models.NotificationSubscription
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&subscriptions).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve subscriptions", err)
+	}
+	subscriptionResponses := make([]models.SubscriptionResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		subscriptionResponses = append(subscriptionResponses, newSubscriptionResponse(subscription))
+	}
+	return c.JSON(fiber.Map{
+		"subscriptions": subscriptionResponses,
+	})
+}
+
+func DeleteSubscription(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	subscriptionID, _ := strconv.Atoi(c.Params("subscriptionID"))
+	result := db.Where("id = ? AND user_id = ?", subscriptionID, userID).Delete(&models.NotificationSubscription{})
+	if result.Error != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete subscription", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return handleError(c, fiber.StatusNotFound, "Subscription not found", nil)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Subscription deleted successfully.",
+	})
+}
+
+// Returns whether every notification type is delivered over every channel. Delivery is on unless turned off.
+func GetNotificationPreferences(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	preferences, err := loadNotificationPreferences(db, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve preferences", err)
+	}
+	return c.JSON(fiber.Map{
+		"preferences": preferences,
+	})
+}
+
+// Turns delivery of notification types over channels on or off. Types and channels left out keep their setting.
+func UpdateNotificationPreferences(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.PreferenceRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	preferences := make([]models.NotificationPreference, 0, len(request.Preferences))
+	for _, item := range request.Preferences {
+		if !models.IsNotificationType(item.Type) {
+			return handleError(c, fiber.StatusBadRequest, "Unknown notification type "+item.Type, nil)
+		}
+		preferences = append(preferences, models.NotificationPreference{
+			UserID:  userID,
+			Type:    item.Type,
+			Channel: item.Channel,
+			Enabled: item.Enabled,
+		})
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}, {Name: "channel"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled"}),
+	}).Create(&preferences).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update preferences", err)
+	}
+
+	updated, err := loadNotificationPreferences(db, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve preferences", err)
+	}
+	return c.JSON(fiber.Map{
+		"preferences": updated,
+	})
+}
+
+func loadNotificationPreferences(db *gorm.DB, userID uint) ([]models.NotificationPreferenceItem, error) {
+	var stored []models.NotificationPreference
+	if err := db.Where("user_id = ?", userID).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	enabled := map[string]bool{}
+	for _, preference := range stored {
+		enabled[preference.Type+"/"+preference.Channel] = preference.Enabled
+	}
+
+	preferences := make([]models.NotificationPreferenceItem, 0, len(models.NotificationTypes)*len(models.NotificationChannels))
+	for _, notificationType := range models.NotificationTypes {
+		for _, channel := range models.NotificationChannels {
+			value, ok := enabled[notificationType+"/"+channel]
+			preferences = append(preferences, models.NotificationPreferenceItem{
+				Type:    notificationType,
+				Channel: channel,
+				Enabled: !ok || value,
+			})
+		}
+	}
+	return preferences, nil
+}
+
+func newSubscriptionResponse(subscription models.NotificationSubscription) models.SubscriptionResponse {
+	return models.SubscriptionResponse{
+		ID:        subscription.ID,
+		Channel:   subscription.Channel,
+		Endpoint:  subscription.Endpoint,
+		Disabled:  subscription.DisabledAt != nil,
+		CreatedAt: subscription.CreatedAt,
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Returns the precomputed "people you may know" suggestions of the requesting user. Connections made or blocks
+// added since the last computation are filtered out here, so a suggestion never goes stale in a visible way.
+func GetSuggestions(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	following := db.Model(&models.UserFollower{}).Select("target_id").Where("source_id = ?", viewerID)
+	friends := db.Model(&models.UserFriend{}).
+		Select("CASE WHEN source_id = ? THEN target_id ELSE source_id END", viewerID).
+		Where("source_id = ? OR target_id = ?", viewerID, viewerID)
+
+	var suggestions []models.UserSuggestion
+	err = db.Preload("Suggested").Where("user_id = ?", viewerID).
+		Where("suggested_id NOT IN (?) AND suggested_id NOT IN (?)", following, friends).
+		Where("suggested_id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).
+		Order("score DESC, suggested_id").Limit(limit).Offset(offset).Find(&suggestions).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve suggestions", err)
+	}
+
+	users := make([]models.User, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		users = append(users, suggestion.Suggested)
+	}
+	userProfiles, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve suggestions", err)
+	}
+
+	suggestionResponses := make([]models.SuggestionResponse, 0, len(suggestions))
+	for i, suggestion := range suggestions {
+		suggestionResponses = append(suggestionResponses, models.SuggestionResponse{
+			Profile:       userProfiles[i],
+			MutualFriends: suggestion.MutualFriends,
+			MutualFollows: suggestion.MutualFollows,
+			SharedGroups:  suggestion.SharedGroups,
+			Score:         suggestion.Score,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"suggestions": suggestionResponses,
+	})
+}
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"strconv"
 	"time"
@@ -10,9 +9,13 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/events"
+	"github.com/coaltail/GoOrders/jobs"
+	"github.com/coaltail/GoOrders/middlewares"
 	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/coaltail/GoOrders/stream"
 	"github.com/coaltail/GoOrders/validation"
-	"github.com/jinzhu/copier"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -23,30 +26,51 @@ import (
 var validator = validation.XValidator{}
 
 func CreateUser(c *fiber.Ctx) error {
-	var user models.User
+	var request models.CreateUserRequest
 
-	if err := c.BodyParser(&user); err != nil {
+	if err := c.BodyParser(&request); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Invalid request",
 			"error":   err,
 		})
 	}
-	validation_errors := validator.Validate(user)
+	validation_errors := validator.Validate(request)
 	if len(validation_errors) > 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"errors": validation_errors,
 		})
 	}
 	//Hash the password
-	password, err := HashPassword(user.PasswordHash)
+	password, err := HashPassword(request.PasswordHash)
 	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Failed to hash password", err)
 	}
-	user.PasswordHash = password
+	// Only the fields of the request are copied, counters, profile images, usernames and the like have their own
+	// endpoints or are never set by users
+	user := models.User{
+		FirstName:             request.FirstName,
+		MiddleName:            request.MiddleName,
+		LastName:              request.LastName,
+		Mobile:                request.Mobile,
+		Email:                 request.Email,
+		PasswordHash:          password,
+		Intro:                 request.Intro,
+		EmailVisibility:       request.EmailVisibility,
+		MobileVisibility:      request.MobileVisibility,
+		IntroVisibility:       request.IntroVisibility,
+		ConnectionsVisibility: request.ConnectionsVisibility,
+		IsPrivate:             request.IsPrivate,
+		RegisteredAt:          time.Now(),
+	}
 	db := database.DB.Db
-	result := db.Create(&user)
-	if result.Error != nil {
-		return handleError(c, fiber.StatusInternalServerError, "Failed to create user", result.Error)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return events.Publish(tx, events.UserCreated{UserID: user.ID})
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Failed to create user", err)
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(user)
@@ -54,12 +78,18 @@ func CreateUser(c *fiber.Ctx) error {
 
 func ListAllUsers(c *fiber.Ctx) error {
 	var users []models.User
-	var userProfiles []models.UserProfile
 	db := database.DB.Db
-	if err := db.Find(&users).Error; err != nil {
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	if err := db.Where("id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).Find(&users).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find users", err)
+	}
+	userProfiles, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not find users", err)
 	}
-	copier.Copy(&userProfiles, &users)
 	return c.JSON(userProfiles)
 }
 
@@ -86,6 +116,13 @@ func LoginUser(c *fiber.Ctx) error {
 		return handleError(c, fiber.StatusUnauthorized, "Invalid credentials", err)
 	}
 
+	// Logging in is how deactivated users come back
+	if user.Status == models.UserStatusDeactivated {
+		if err := db.Model(&user).UpdateColumn(models.UserStatusColumn, models.UserStatusActive).Error; err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Failed to log in user", err)
+		}
+	}
+
 	// Check if the user already has a token
 	var existingToken models.Token
 	tokenDB := db.Model(&user).Association("Token")
@@ -97,6 +134,7 @@ func LoginUser(c *fiber.Ctx) error {
 		"ID":    user.ID,
 		"email": user.Email,
 		"exp":   tokenExpiry,
+		"iat":   time.Now().Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -126,21 +164,58 @@ func LoginUser(c *fiber.Ctx) error {
 	})
 }
 
+func GetMyProfile(c *fiber.Ctx) error {
+	var user models.User
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	if err := db.First(&user, viewerID).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+
+	userProfile, err := projectUserProfile(db, viewerID, user)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+	return c.JSON(fiber.Map{
+		"profile": userProfile,
+		"privacy": user.PrivacySettings(),
+	})
+}
+
 func GetUserProfileByID(c *fiber.Ctx) error {
 	var user models.User
 	db := database.DB.Db
 	id, _ := strconv.Atoi(c.Params("id"))
-	// If the user is authorized, proceed to fetch the user profile
-	err := models.QueryAndReturnError(c, db, &user, func(db *gorm.DB) *gorm.DB {
-		return db.Where("id = ?", id)
-	})
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
 
+	// Any authenticated user can read a profile, the projection hides what the privacy settings do not allow
+	err = db.Scopes(models.ActiveUsers).First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
 	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
 	}
+	// Blocked users must not learn anything about each other, so they get the same answer as for a missing user
+	blocked, err := models.IsBlocked(db, viewerID, user.ID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+	if blocked {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", nil)
+	}
 
-	var userProfile models.UserProfile
-	copier.Copy(&userProfile, &user)
+	userProfile, err := projectUserProfile(db, viewerID, user)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
 	return c.JSON(userProfile)
 }
 
@@ -154,22 +229,150 @@ func UpdateUserProfileByID(c *fiber.Ctx) error {
 	})
 
 	if err != nil {
-		handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
 	}
 
-	// Copy contents of user to the new variable
-	newUser := &user
-
-	// Parse the request body into newUser
-	if err := c.BodyParser(newUser); err != nil {
+	// Fields missing from the body keep their current values
+	request := models.UpdateUserRequest{
+		FirstName:  user.FirstName,
+		MiddleName: user.MiddleName,
+		LastName:   user.LastName,
+		Mobile:     user.Mobile,
+		Email:      user.Email,
+		Intro:      user.Intro,
+	}
+	if err := c.BodyParser(&request); err != nil {
 		return handleError(c, fiber.StatusBadRequest, "Invalid data", err)
 	}
-	db.Save(&newUser)
-	var userProfile models.UserProfile
-	copier.Copy(&userProfile, &newUser)
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+	// Profile images, usernames, privacy settings and passwords are only changed through their own endpoints,
+	// admins and account status only in the database
+	user.FirstName, user.MiddleName, user.LastName = request.FirstName, request.MiddleName, request.LastName
+	user.Mobile, user.Email, user.Intro = request.Mobile, request.Email, request.Intro
+	if err := db.Model(&user).Select(models.UpdateUserColumns).Updates(&user).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update user", err)
+	}
+	userProfile, err := projectUserProfile(db, uint(id), user)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
+	return c.JSON(userProfile)
+}
+
+// Sets the username others can @mention the user with. Usernames are unique regardless of case.
+func UpdateUsername(c *fiber.Ctx) error {
+	var user models.User
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var request models.UsernameRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+	if !models.UsernamePattern.MatchString(request.Username) {
+		return handleError(c, fiber.StatusBadRequest, "Usernames may only contain letters, digits and underscores", nil)
+	}
+
+	if err := db.First(&user, id).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	var taken int64
+	err := db.Model(&models.User{}).Where("lower(username) = lower(?) AND id <> ?", request.Username, user.ID).Count(&taken).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update username", err)
+	}
+	if taken > 0 {
+		return handleError(c, fiber.StatusConflict, "This username is already taken.", nil)
+	}
+
+	if err := db.Model(&user).UpdateColumn("username", request.Username).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update username", err)
+	}
+	user.Username = request.Username
+	userProfile, err := projectUserProfile(db, user.ID, user)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
+	}
 	return c.JSON(userProfile)
 }
 
+func GetPrivacySettings(c *fiber.Ctx) error {
+	var user models.User
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	if err := db.First(&user, id).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	return c.JSON(user.PrivacySettings())
+}
+
+func UpdatePrivacySettings(c *fiber.Ctx) error {
+	var user models.User
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	if err := db.First(&user, id).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+
+	// Start from the current settings so a partial update keeps the fields it does not mention
+	settings := user.PrivacySettings()
+	if err := c.BodyParser(&settings); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid data", err)
+	}
+	validation_errors := validator.Validate(settings)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	wasPrivate := user.IsPrivate
+	var requests []models.UserFollower
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"email_visibility":       settings.EmailVisibility,
+			"mobile_visibility":      settings.MobileVisibility,
+			"intro_visibility":       settings.IntroVisibility,
+			"connections_visibility": settings.ConnectionsVisibility,
+			"is_private":             settings.IsPrivate,
+		}).Error
+		if err != nil || !wasPrivate || settings.IsPrivate {
+			return err
+		}
+
+		// A public account has nothing left to approve, so every pending follow request is approved
+		if err := tx.Where("target_id = ? AND type = ?", user.ID, models.FollowTypePending).Find(&requests).Error; err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if err := approveFollowRequest(tx, request); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update privacy settings", err)
+	}
+	for _, request := range requests {
+		publishFollowerEvent(request, stream.FollowAdded)
+	}
+	return c.JSON(settings)
+}
+
 func DeleteUserByID(c *fiber.Ctx) error {
 	var user models.User
 	db := database.DB.Db
@@ -183,45 +386,167 @@ func DeleteUserByID(c *fiber.Ctx) error {
 		return handleError(c, fiber.StatusInternalServerError, "Could not find user", err)
 	}
 
-	if err := db.Delete(&user).Error; err != nil {
+	// The account can be restored until the purge job runs, which deletes everything that belongs to it
+	deletedAt := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		if err := models.RevokeSessions(tx, user.ID); err != nil {
+			return err
+		}
+		return jobs.EnqueuePurgeUser(tx, user.ID, deletedAt)
+	})
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Error deleting user", err)
 	}
 
-	return c.JSON(fiber.Map{"detail": "success"})
+	return c.JSON(fiber.Map{
+		"detail":         "success",
+		"restore_before": deletedAt.Add(jobs.UserDeletionGracePeriod()),
+	})
+}
+
+// Hides the requesting user's profile, posts and connections from everyone and signs them out everywhere, until they
+// log in again.
+func DeactivateUser(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", id).UpdateColumn(models.UserStatusColumn, models.UserStatusDeactivated)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return models.RevokeSessions(tx, uint(id))
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not deactivate user", err)
+	}
+	return c.JSON(fiber.Map{"detail": "Account deactivated. Log in again to reactivate it."})
+}
+
+// Restores a deleted account during the grace period. Sessions stay revoked, so the user has to log in again.
+func RestoreUser(c *fiber.Ctx) error {
+	loginRequest := new(models.LoginRequest)
+	if err := c.BodyParser(loginRequest); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Could not parse request", err)
+	}
+
+	var user models.User
+	db := database.DB.Db
+	err := db.Unscoped().Where("email = ? AND deleted_at > ?", loginRequest.Email, time.Now().Add(-jobs.UserDeletionGracePeriod())).
+		First(&user).Error
+	// Unknown emails and wrong passwords get the same answer, so neither reveals whether an account exists
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusInternalServerError, "Could not restore user", err)
+	}
+	if err != nil || !CheckPasswordHash(loginRequest.Password, user.PasswordHash) {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid credentials or no deleted account to restore", nil)
+	}
+
+	if err := db.Unscoped().Model(&user).UpdateColumn("deleted_at", nil).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not restore user", err)
+	}
+	return c.JSON(fiber.Map{"detail": "Account restored successfully."})
 }
 
 func GetUserFollowers(c *fiber.Ctx) error {
-	startTime := time.Now()
 	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
 
+	var owner models.User
 	var followers []models.UserFollower
-	var userProfile []models.UserProfile
+	var users []models.User
+
+	ownerID, _ := strconv.Atoi(c.Params("id"))
+	if err := db.Scopes(models.ActiveUsers).First(&owner, ownerID).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	allowed, err := canViewConnections(db, viewerID, owner)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followers", err)
+	}
+	if !allowed {
+		return handleError(c, fiber.StatusForbidden, "This user's followers are private.", nil)
+	}
 
-	// Query the database to find followers and preload the Target user's profile
-	if err := db.Preload("Target").Where("source_id = ?", c.Params("id")).Find(&followers).Error; err != nil {
+	// Followers are the users following :id, so :id is the target and the Source user's profile is preloaded
+	err = db.Preload("Source").Where("target_id = ? AND type = ?", owner.ID, models.FollowTypeActive).
+		Where("source_id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).
+		Find(&followers).Error
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followers", err)
 	}
 
 	// Extract the UserProfile data from the followers
 	for _, follower := range followers {
-		userProfile = append(userProfile, models.UserProfile{
-			ID:         follower.Target.ID,
-			FirstName:  follower.Target.FirstName,
-			MiddleName: follower.Target.MiddleName,
-			LastName:   follower.Target.LastName,
-			Mobile:     follower.Target.Mobile,
-			Email:      follower.Target.Email,
-			Intro:      follower.Target.Intro,
-		})
+		users = append(users, follower.Source)
+	}
+	userProfile, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followers", err)
 	}
 
-	fmt.Println("This operation took: ", time.Since(startTime))
 	return c.JSON(fiber.Map{
 		"followers": userProfile,
 	})
 
 }
 
+func GetUserFollowing(c *fiber.Ctx) error {
+	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var owner models.User
+	var following []models.UserFollower
+	var users []models.User
+
+	ownerID, _ := strconv.Atoi(c.Params("id"))
+	if err := db.Scopes(models.ActiveUsers).First(&owner, ownerID).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	allowed, err := canViewConnections(db, viewerID, owner)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followed users", err)
+	}
+	if !allowed {
+		return handleError(c, fiber.StatusForbidden, "The users this user follows are private.", nil)
+	}
+
+	// Following are the users :id follows, so :id is the source and the Target user's profile is preloaded
+	err = db.Preload("Target").Where("source_id = ? AND type = ?", owner.ID, models.FollowTypeActive).
+		Where("target_id NOT IN (?)", models.HiddenUserIDs(db, viewerID)).
+		Find(&following).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followed users", err)
+	}
+
+	for _, follow := range following {
+		users = append(users, follow.Target)
+	}
+	userProfile, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followed users", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"following": userProfile,
+	})
+}
+
 func FollowUser(c *fiber.Ctx) error {
 	var sourceUser, targetUser models.User
 	db := database.DB.Db
@@ -236,27 +561,160 @@ func FollowUser(c *fiber.Ctx) error {
 		return handleError(c, fiber.StatusNotFound, "Source user not found", err)
 	}
 
-	err = db.First(&targetUser, targetID).Error
+	err = db.Scopes(models.ActiveUsers).First(&targetUser, targetID).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return handleError(c, fiber.StatusNotFound, "Target user not found", err)
 	}
 
+	blocked, err := models.IsBlocked(db, uint(sourceID), uint(targetID))
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create follower", err)
+	}
+	if blocked {
+		return handleError(c, fiber.StatusForbidden, "You cannot follow this user.", nil)
+	}
+
+	// Following a private account only creates a request, which becomes a follow once the target approves it
+	followType := models.FollowTypeActive
+	if targetUser.IsPrivate {
+		followType = models.FollowTypePending
+	}
+
 	userFollower = models.UserFollower{
 		SourceID: uint(sourceID),
 		Source:   sourceUser,
 		TargetID: uint(targetID),
 		Target:   targetUser,
-		Type:     0, //0 - basic type of follow, for now
+		Type:     followType,
 	}
-	if err := db.Create(&userFollower).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&userFollower).Error; err != nil {
+			return err
+		}
+		if followType == models.FollowTypePending {
+			err := events.Publish(tx, events.FollowRequested{FollowerID: uint(sourceID), FolloweeID: uint(targetID)})
+			if err != nil {
+				return err
+			}
+			return notifications.NotifyUser(tx, uint(targetID), uint(sourceID), models.NotificationFollowRequest)
+		}
+		if err := events.Publish(tx, events.UserFollowed{FollowerID: uint(sourceID), FolloweeID: uint(targetID)}); err != nil {
+			return err
+		}
+		if err := notifications.NotifyUser(tx, uint(targetID), uint(sourceID), models.NotificationFollow); err != nil {
+			return err
+		}
+		if err := models.IncrementUserCounter(tx, uint(sourceID), models.FollowingCountColumn, 1); err != nil {
+			return err
+		}
+		return models.IncrementUserCounter(tx, uint(targetID), models.FollowerCountColumn, 1)
+	})
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not create follower", err)
 	}
+
+	detail := "Followed successfully"
+	action := stream.FollowAdded
+	if followType == models.FollowTypePending {
+		detail = "Follow request sent successfully"
+		action = stream.FollowRequested
+	}
+	publishFollowerEvent(userFollower, action)
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"detail":   "Followed successfully",
+		"detail":   detail,
 		"follower": userFollower,
 	})
 }
 
+func GetFollowRequests(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var requests []models.UserFollower
+	var users []models.User
+
+	err := db.Preload("Source").Where("target_id = ? AND type = ?", id, models.FollowTypePending).
+		Where("source_id NOT IN (?)", models.DeactivatedUserIDs(db)).Order("created_at DESC").Find(&requests).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve follow requests", err)
+	}
+
+	for _, request := range requests {
+		users = append(users, request.Source)
+	}
+	userProfile, err := projectUserProfiles(db, uint(id), users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve follow requests", err)
+	}
+	return c.JSON(fiber.Map{
+		"requests": userProfile,
+	})
+}
+
+func ApproveFollowRequest(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	requesterID, _ := strconv.Atoi(c.Params("targetID"))
+	db := database.DB.Db
+
+	var request models.UserFollower
+	err := db.Where("source_id = ? AND target_id = ? AND type = ?", requesterID, id, models.FollowTypePending).First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Follow request not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find follow request", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return approveFollowRequest(tx, request)
+	})
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not approve follow request", err)
+	}
+	publishFollowerEvent(request, stream.FollowAdded)
+	return c.JSON(fiber.Map{
+		"detail": "Follow request approved",
+	})
+}
+
+func RejectFollowRequest(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	requesterID, _ := strconv.Atoi(c.Params("targetID"))
+	db := database.DB.Db
+
+	result := db.Unscoped().Where("source_id = ? AND target_id = ? AND type = ?", requesterID, id, models.FollowTypePending).
+		Delete(&models.UserFollower{})
+	if result.Error != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not reject follow request", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return handleError(c, fiber.StatusNotFound, "Follow request not found", nil)
+	}
+	publishFollowerEvent(models.UserFollower{SourceID: uint(requesterID), TargetID: uint(id)}, stream.FollowRemoved)
+	return c.JSON(fiber.Map{
+		"detail": "Follow request rejected",
+	})
+}
+
+// Turns a pending follow into an active one, bumps the counters and publishes the follow. It has to run inside a
+// transaction.
+func approveFollowRequest(tx *gorm.DB, request models.UserFollower) error {
+	result := tx.Model(&request).Where("type = ?", models.FollowTypePending).Update("type", models.FollowTypeActive)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	if err := events.Publish(tx, events.UserFollowed{FollowerID: request.SourceID, FolloweeID: request.TargetID}); err != nil {
+		return err
+	}
+	if err := models.IncrementUserCounter(tx, request.SourceID, models.FollowingCountColumn, 1); err != nil {
+		return err
+	}
+	if err := models.IncrementUserCounter(tx, request.TargetID, models.FollowerCountColumn, 1); err != nil {
+		return err
+	}
+	return notifications.NotifyUser(tx, request.SourceID, request.TargetID, models.NotificationFollowAccepted)
+}
+
 func UnfollowUser(c *fiber.Ctx) error {
 	sourceID, _ := strconv.Atoi(c.Params("id"))
 	targetID, _ := strconv.Atoi(c.Params("targetID"))
@@ -264,48 +722,72 @@ func UnfollowUser(c *fiber.Ctx) error {
 	db := database.DB.Db
 	var userFollower models.UserFollower
 
-	err := models.QueryAndReturnError(c, db, &userFollower, func(db *gorm.DB) *gorm.DB {
-		return db.Where("source_id = ?", sourceID).Where("target_id = ?", targetID)
-	})
-
+	err := db.Where("source_id = ?", sourceID).Where("target_id = ?", targetID).First(&userFollower).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Could not find record.", err)
+	}
 	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not find record.", err)
 	}
 
-	if err := db.Unscoped().Where("source_id = ?", sourceID).Where("target_id = ?", targetID).Delete(&userFollower).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return deleteUserFollower(tx, userFollower)
+	})
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not delete record", err)
 	}
+	publishFollowerEvent(userFollower, stream.FollowRemoved)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"detail": "Record deleted succesfully.",
 	})
 }
 
 func GetUserFriends(c *fiber.Ctx) error {
-	startTime := time.Now()
 	db := database.DB.Db
+	viewerID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
 
+	var owner models.User
 	var friends []models.UserFriend
-	var userProfile []models.UserProfile
+	var users []models.User
+
+	ownerID, _ := strconv.Atoi(c.Params("id"))
+	if err := db.Scopes(models.ActiveUsers).First(&owner, ownerID).Error; err != nil {
+		return handleError(c, fiber.StatusNotFound, "Could not find user", err)
+	}
+	allowed, err := canViewConnections(db, viewerID, owner)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve friends", err)
+	}
+	if !allowed {
+		return handleError(c, fiber.StatusForbidden, "This user's friends are private.", nil)
+	}
 
-	// Query the database to find followers and preload the Target user's profile
-	if err := db.Preload("Target").Where("source_id = ?", c.Params("id")).Find(&friends).Error; err != nil {
+	// A friendship is a single accepted row in either direction, so preload both sides and keep the other user
+	hiddenUserIDs := models.HiddenUserIDs(db, viewerID)
+	err = db.Preload("Source").Preload("Target").
+		Where("(source_id = ? OR target_id = ?) AND status = ?", owner.ID, owner.ID, models.FriendStatusAccepted).
+		Where("source_id NOT IN (?) AND target_id NOT IN (?)", hiddenUserIDs, hiddenUserIDs).
+		Find(&friends).Error
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followers", err)
 	}
 
 	// Extract the UserProfile data from the followers
 	for _, friend := range friends {
-		userProfile = append(userProfile, models.UserProfile{
-			ID:         friend.Target.ID,
-			FirstName:  friend.Target.FirstName,
-			MiddleName: friend.Target.MiddleName,
-			LastName:   friend.Target.LastName,
-			Mobile:     friend.Target.Mobile,
-			Email:      friend.Target.Email,
-			Intro:      friend.Target.Intro,
-		})
+		if friend.SourceID == owner.ID {
+			users = append(users, friend.Target)
+		} else {
+			users = append(users, friend.Source)
+		}
+	}
+	userProfile, err := projectUserProfiles(db, viewerID, users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve followers", err)
 	}
 
-	fmt.Println("This operation took: ", time.Since(startTime))
 	return c.JSON(fiber.Map{
 		"followers": userProfile,
 	})
@@ -326,30 +808,136 @@ func CreateUserFriends(c *fiber.Ctx) error {
 		return handleError(c, fiber.StatusNotFound, "Source user not found", err)
 	}
 
-	err = db.First(&targetUser, targetID).Error
+	err = db.Scopes(models.ActiveUsers).First(&targetUser, targetID).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return handleError(c, fiber.StatusNotFound, "Target user not found", err)
 	}
 
+	blocked, err := models.IsBlocked(db, uint(sourceID), uint(targetID))
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create follower", err)
+	}
+	if blocked {
+		return handleError(c, fiber.StatusForbidden, "You cannot send a friend request to this user.", nil)
+	}
+
+	// If the target already sent us a request, sending one back accepts it
+	var existing models.UserFriend
+	err = db.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+		First(&existing).Error
+	if err == nil {
+		if existing.Status == models.FriendStatusPending && existing.TargetID == uint(sourceID) {
+			if err := acceptFriendRequest(db, &existing); err != nil {
+				return handleError(c, fiber.StatusInternalServerError, "Could not accept friend request", err)
+			}
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"detail":   "Friend request accepted",
+				"follower": existing,
+			})
+		}
+		return handleError(c, fiber.StatusConflict, "A friendship or friend request already exists.", nil)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create follower", err)
+	}
+
 	userFollower = models.UserFriend{
 		SourceID: uint(sourceID),
 		Source:   sourceUser,
 		TargetID: uint(targetID),
 		Target:   targetUser,
 		Type:     0, //0 - basic type of friend, for now
-		Status:   0,
+		Status:   models.FriendStatusPending,
 		Notes:    "",
 	}
-	if err := db.Create(&userFollower).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&userFollower).Error; err != nil {
+			return err
+		}
+		return notifications.NotifyUser(tx, uint(targetID), uint(sourceID), models.NotificationFriendRequest)
+	})
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not create follower", err)
 	}
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"detail":   "Friend created successfully",
+		"detail":   "Friend request sent successfully",
 		"follower": userFollower,
 	})
 
 }
 
+func GetFriendRequests(c *fiber.Ctx) error {
+	db := database.DB.Db
+	id, _ := strconv.Atoi(c.Params("id"))
+
+	var requests []models.UserFriend
+	var users []models.User
+
+	// Incoming requests are the pending rows where the user is the target
+	err := db.Preload("Source").Where("target_id = ? AND status = ?", id, models.FriendStatusPending).
+		Where("source_id NOT IN (?)", models.DeactivatedUserIDs(db)).Order("created_at DESC").Find(&requests).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve friend requests", err)
+	}
+
+	for _, request := range requests {
+		users = append(users, request.Source)
+	}
+	userProfile, err := projectUserProfiles(db, uint(id), users)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve friend requests", err)
+	}
+	return c.JSON(fiber.Map{
+		"requests": userProfile,
+	})
+}
+
+func AcceptFriendRequest(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	requesterID, _ := strconv.Atoi(c.Params("targetID"))
+	db := database.DB.Db
+
+	var request models.UserFriend
+	err := db.Where("source_id = ? AND target_id = ? AND status = ?", requesterID, id, models.FriendStatusPending).First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Friend request not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find friend request", err)
+	}
+
+	if err := acceptFriendRequest(db, &request); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not accept friend request", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Friend request accepted",
+	})
+}
+
+// Marks a pending request as accepted and bumps the friend counters of both users in one transaction.
+func acceptFriendRequest(db *gorm.DB, request *models.UserFriend) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(request).Where("status = ?", models.FriendStatusPending).Update("status", models.FriendStatusAccepted)
+		if result.Error != nil {
+			return result.Error
+		}
+		// Someone else accepted it in the meantime, the counters were already updated
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if err := models.IncrementUserCounter(tx, request.SourceID, models.FriendCountColumn, 1); err != nil {
+			return err
+		}
+		if err := models.IncrementUserCounter(tx, request.TargetID, models.FriendCountColumn, 1); err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.FriendRequestAccepted{RequesterID: request.SourceID, AccepterID: request.TargetID}); err != nil {
+			return err
+		}
+		return notifications.NotifyUser(tx, request.SourceID, request.TargetID, models.NotificationFriendAccepted)
+	})
+}
+
 func DeleteUserFriends(c *fiber.Ctx) error {
 	sourceID, _ := strconv.Atoi(c.Params("id"))
 	targetID, _ := strconv.Atoi(c.Params("targetID"))
@@ -357,15 +945,20 @@ func DeleteUserFriends(c *fiber.Ctx) error {
 	db := database.DB.Db
 	var userFriend models.UserFriend
 
-	err := models.QueryAndReturnError(c, db, &userFriend, func(db *gorm.DB) *gorm.DB {
-		return db.Where("source_id = ?", sourceID).Where("target_id = ?", targetID)
-	})
-
+	// Removes a friendship or a pending request, whichever of the two users sent it
+	err := db.Where("(source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", sourceID, targetID, targetID, sourceID).
+		First(&userFriend).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Could not find record.", err)
+	}
 	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not find record.", err)
 	}
 
-	if err := db.Unscoped().Where("source_id = ?", sourceID).Where("target_id = ?", targetID).Delete(&userFriend).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return deleteUserFriend(tx, userFriend)
+	})
+	if err != nil {
 		return handleError(c, fiber.StatusInternalServerError, "Could not delete record", err)
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -373,6 +966,32 @@ func DeleteUserFriends(c *fiber.Ctx) error {
 	})
 }
 
+// Hard-deletes a follower row and, if it was an active follow, decrements the matching counters.
+// It has to run inside a transaction.
+func deleteUserFollower(tx *gorm.DB, userFollower models.UserFollower) error {
+	result := tx.Unscoped().Delete(&userFollower)
+	if result.Error != nil || result.RowsAffected == 0 || userFollower.Type != models.FollowTypeActive {
+		return result.Error
+	}
+	if err := models.IncrementUserCounter(tx, userFollower.SourceID, models.FollowingCountColumn, -1); err != nil {
+		return err
+	}
+	return models.IncrementUserCounter(tx, userFollower.TargetID, models.FollowerCountColumn, -1)
+}
+
+// Hard-deletes a friend row and, if it was an accepted friendship, decrements both friend counters.
+// It has to run inside a transaction.
+func deleteUserFriend(tx *gorm.DB, userFriend models.UserFriend) error {
+	result := tx.Unscoped().Delete(&userFriend)
+	if result.Error != nil || result.RowsAffected == 0 || userFriend.Status != models.FriendStatusAccepted {
+		return result.Error
+	}
+	if err := models.IncrementUserCounter(tx, userFriend.SourceID, models.FriendCountColumn, -1); err != nil {
+		return err
+	}
+	return models.IncrementUserCounter(tx, userFriend.TargetID, models.FriendCountColumn, -1)
+}
+
 // The handleError function allows for customizable and quick error formatting.
 func handleError(c *fiber.Ctx, statusCode int, message string, err error) error {
 	if err != nil {
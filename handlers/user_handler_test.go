@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func TestUpdateUserProfileByIDWritesProfileFieldsOnly(t *testing.T) {
+	statements := useTestDB(t)
+	body := `{"FirstName":"Ana","LastName":"Horvat","Mobile":"0911234567","Email":"ana@example.com",` +
+		`"ID":2,"PasswordHash":"hash","DeletedAt":"2023-03-15T10:00:00Z","IsAdmin":true,"Status":1,"IsPrivate":true,"AvatarKey":"a.png"}`
+	if status, _ := send(t, fiber.MethodPatch, "/users/:id/update", "/users/1/update", body, UpdateUserProfileByID); status != fiber.StatusOK {
+		t.Fatalf("status = %d, want %d", status, fiber.StatusOK)
+	}
+
+	var update string
+	for _, statement := range *statements {
+		if strings.HasPrefix(statement, `UPDATE "users"`) {
+			update = statement
+		}
+	}
+	update = regexp.MustCompile(`"updated_at"='[^']*'`).ReplaceAllString(update, `"updated_at"=?`)
+	want := `UPDATE "users" SET "updated_at"=?,"first_name"='Ana',"middle_name"='',"last_name"='Horvat',"mobile"='0911234567',` +
+		`"email"='ana@example.com',"intro"='' WHERE "users"."deleted_at" IS NULL AND "id" = 1`
+	if update != want {
+		t.Errorf("update = %s, want %s", update, want)
+	}
+}
+
+func TestUpdateUserProfileByIDValidates(t *testing.T) {
+	statements := useTestDB(t)
+	if status, _ := send(t, fiber.MethodPatch, "/users/:id/update", "/users/1/update", `{"FirstName":""}`, UpdateUserProfileByID); status != fiber.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", status, fiber.StatusBadRequest)
+	}
+	for _, statement := range *statements {
+		if strings.HasPrefix(statement, "UPDATE") {
+			t.Errorf("invalid profile was written: %s", statement)
+		}
+	}
+}
+
+func TestApproveFollowRequestPublishesFollow(t *testing.T) {
+	statements := useTestDB(t)
+	db := database.DB.Db
+	db.Callback().Query().Before("gorm:preload").Register("test:request", func(tx *gorm.DB) {
+		if request, ok := tx.Statement.Dest.(*models.UserFollower); ok {
+			*request = models.UserFollower{SourceID: 2, TargetID: 1, Type: models.FollowTypePending}
+			request.ID = 3
+		}
+	})
+	// The pending follow is found and updated
+	db.Callback().Update().After("gorm:update").Register("test:affected", func(tx *gorm.DB) {
+		tx.RowsAffected = 1
+	})
+
+	route := "/users/:id/followers/:targetID/approve"
+	if status, body := send(t, fiber.MethodPost, route, "/users/1/followers/2/approve", "", ApproveFollowRequest); status != fiber.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, fiber.StatusOK, body)
+	}
+	want := `INSERT INTO "outbox_events" ("name","payload",`
+	payload := `'user.followed','{"followerID":2,"followeeID":1}'`
+	for _, statement := range *statements {
+		if strings.HasPrefix(statement, want) && strings.Contains(statement, payload) {
+			return
+		}
+	}
+	t.Errorf("no follow was published in %q", *statements)
+}
+
+func TestFollowUserPublishes(t *testing.T) {
+	tests := []struct {
+		name      string
+		isPrivate bool
+		event     string
+		other     string
+	}{
+		{"public account", false, `'user.followed','{"followerID":1,"followeeID":2}'`, "'follow.requested'"},
+		{"private account", true, `'follow.requested','{"followerID":1,"followeeID":2}'`, "'user.followed'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements := useTestDB(t)
+			// The target is looked up among active users, the follower is not
+			database.DB.Db.Callback().Query().Before("gorm:preload").Register("test:target", func(tx *gorm.DB) {
+				if user, ok := tx.Statement.Dest.(*models.User); ok && strings.Contains(tx.Statement.SQL.String(), "status") {
+					user.ID, user.IsPrivate = 2, tt.isPrivate
+				}
+			})
+
+			route := "/users/:id/following/:targetID"
+			if status, body := send(t, fiber.MethodPost, route, "/users/1/following/2", "", FollowUser); status != fiber.StatusCreated {
+				t.Fatalf("status = %d, want %d: %s", status, fiber.StatusCreated, body)
+			}
+			var published []string
+			for _, statement := range *statements {
+				if strings.HasPrefix(statement, `INSERT INTO "outbox_events"`) {
+					published = append(published, statement)
+				}
+			}
+			if len(published) != 1 || !strings.Contains(published[0], tt.event) || strings.Contains(published[0], tt.other) {
+				t.Errorf("published %q, want only %s", published, tt.event)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/webhooks"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Lists the events the requesting user's webhooks can receive. Admins can also create global webhooks, which can
+// receive every event.
+func GetWebhookEventTypes(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	isAdmin, err := models.IsAdmin(db, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve event types", err)
+	}
+
+	response := fiber.Map{
+		"events": webhooks.UserEventTypes(),
+	}
+	if isAdmin {
+		response["globalEvents"] = webhooks.EventTypes
+	}
+	return c.JSON(response)
+}
+
+// Registers a webhook of the requesting user. The secret its payloads are signed with is only returned here.
+func CreateWebhook(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	var request models.WebhookRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+	if !isHTTPURL(request.URL) {
+		return handleError(c, fiber.StatusBadRequest, "The URL must be an http or https URL", nil)
+	}
+	if request.Global {
+		isAdmin, err := models.IsAdmin(db, userID)
+		if err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not create webhook", err)
+		}
+		if !isAdmin {
+			return handleError(c, fiber.StatusForbidden, "Only admins can create global webhooks.", nil)
+		}
+	}
+	for _, eventName := range request.Events {
+		if !webhooks.IsEventType(eventName, request.Global) {
+			return handleError(c, fiber.StatusBadRequest, "Unknown event "+eventName, nil)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create webhook", err)
+	}
+	webhook := models.Webhook{
+		OwnerID:  userID,
+		URL:      request.URL,
+		Events:   strings.Join(request.Events, ","),
+		IsGlobal: request.Global,
+		Secret:   hex.EncodeToString(secret),
+	}
+	if err := db.Create(&webhook).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not create webhook", err)
+	}
+
+	webhookResponse := newWebhookResponse(webhook)
+	webhookResponse.Secret = webhook.Secret
+	return c.Status(fiber.StatusCreated).JSON(webhookResponse)
+}
+
+// Lists the webhooks of the requesting user and, for admins, every global webhook.
+func GetWebhooks(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+	isAdmin, err := models.IsAdmin(db, userID)
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve webhooks", err)
+	}
+
+	var found []models.Webhook
+	err = db.Where("owner_id = ? OR (is_global AND ?)", userID, isAdmin).Order("created_at").Find(&found).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve webhooks", err)
+	}
+	webhookResponses := make([]models.WebhookResponse, 0, len(found))
+	for _, webhook := range found {
+		webhookResponses = append(webhookResponses, newWebhookResponse(webhook))
+	}
+	return c.JSON(fiber.Map{
+		"webhooks": webhookResponses,
+	})
+}
+
+// Changes the URL or the events of a webhook, or enables or disables it.
+func UpdateWebhook(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	webhook, err := findWebhook(db, userID, c.Params("webhookID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Webhook not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find webhook", err)
+	}
+
+	var request models.WebhookUpdateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return handleError(c, fiber.StatusBadRequest, "Invalid request", err)
+	}
+	validation_errors := validator.Validate(request)
+	if len(validation_errors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"errors": validation_errors,
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if request.URL != nil {
+		if !isHTTPURL(*request.URL) {
+			return handleError(c, fiber.StatusBadRequest, "The URL must be an http or https URL", nil)
+		}
+		updates["url"] = *request.URL
+	}
+	if len(request.Events) > 0 {
+		for _, eventName := range request.Events {
+			if !webhooks.IsEventType(eventName, webhook.IsGlobal) {
+				return handleError(c, fiber.StatusBadRequest, "Unknown event "+eventName, nil)
+			}
+		}
+		updates["events"] = strings.Join(request.Events, ",")
+	}
+	if request.Enabled != nil {
+		if *request.Enabled {
+			updates["disabled_at"] = nil
+			updates["consecutive_failures"] = 0
+		} else if webhook.DisabledAt == nil {
+			updates["disabled_at"] = time.Now()
+		}
+	}
+	if len(updates) > 0 {
+		if err := db.Model(&webhook).Updates(updates).Error; err != nil {
+			return handleError(c, fiber.StatusInternalServerError, "Could not update webhook", err)
+		}
+	}
+	if err := db.First(&webhook, webhook.ID).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not update webhook", err)
+	}
+	return c.JSON(newWebhookResponse(webhook))
+}
+
+// Deletes a webhook. Deliveries that are still pending are dropped.
+func DeleteWebhook(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	webhook, err := findWebhook(db, userID, c.Params("webhookID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Webhook not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find webhook", err)
+	}
+	if err := db.Delete(&webhook).Error; err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not delete webhook", err)
+	}
+	return c.JSON(fiber.Map{
+		"detail": "Webhook deleted successfully.",
+	})
+}
+
+// Returns the delivery log of a webhook, newest first.
+func GetWebhookDeliveries(c *fiber.Ctx) error {
+	db := database.DB.Db
+	limit, offset := getPagination(c)
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	webhook, err := findWebhook(db, userID, c.Params("webhookID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Webhook not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find webhook", err)
+	}
+
+	var deliveries []models.WebhookDelivery
+	err = db.Where("webhook_id = ?", webhook.ID).Order("id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not retrieve deliveries", err)
+	}
+	deliveryResponses := make([]models.WebhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		deliveryResponses = append(deliveryResponses, newWebhookDeliveryResponse(delivery))
+	}
+	return c.JSON(fiber.Map{
+		"deliveries": deliveryResponses,
+	})
+}
+
+// Queues a delivery again right away, whatever happened to it before. The receiver gets the same event ID again.
+func RedeliverWebhookDelivery(c *fiber.Ctx) error {
+	db := database.DB.Db
+	userID, err := middlewares.GetUserIDFromJWT(c)
+	if err != nil {
+		return handleError(c, fiber.StatusUnauthorized, "Invalid JWT or ID", err)
+	}
+
+	webhook, err := findWebhook(db, userID, c.Params("webhookID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Webhook not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find webhook", err)
+	}
+	if webhook.DisabledAt != nil {
+		return handleError(c, fiber.StatusConflict, "The webhook is disabled, enable it first.", nil)
+	}
+
+	deliveryID, _ := strconv.Atoi(c.Params("deliveryID"))
+	var delivery models.WebhookDelivery
+	err = db.Where("id = ? AND webhook_id = ?", deliveryID, webhook.ID).First(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return handleError(c, fiber.StatusNotFound, "Delivery not found", err)
+	}
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not find delivery", err)
+	}
+
+	err = db.Model(&delivery).Updates(map[string]interface{}{
+		"status":          models.DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"locked_until":    nil,
+	}).Error
+	if err != nil {
+		return handleError(c, fiber.StatusInternalServerError, "Could not redeliver", err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(newWebhookDeliveryResponse(delivery))
+}
+
+// Loads a webhook the user manages, which are their own ones and, for admins, the global ones.
+func findWebhook(db *gorm.DB, userID uint, param string) (models.Webhook, error) {
+	var webhook models.Webhook
+	webhookID, _ := strconv.Atoi(param)
+	isAdmin, err := models.IsAdmin(db, userID)
+	if err != nil {
+		return webhook, err
+	}
+	err = db.Where("id = ? AND (owner_id = ? OR (is_global AND ?))", webhookID, userID, isAdmin).First(&webhook).Error
+	return webhook, err
+}
+
+// Reports whether value is an absolute http or https URL.
+func isHTTPURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+func newWebhookResponse(webhook models.Webhook) models.WebhookResponse {
+	return models.WebhookResponse{
+		ID:                  webhook.ID,
+		URL:                 webhook.URL,
+		Events:              webhook.EventNames(),
+		Global:              webhook.IsGlobal,
+		Disabled:            webhook.DisabledAt != nil,
+		ConsecutiveFailures: webhook.ConsecutiveFailures,
+		CreatedAt:           webhook.CreatedAt,
+	}
+}
+
+func newWebhookDeliveryResponse(delivery models.WebhookDelivery) models.WebhookDeliveryResponse {
+	return models.WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		Event:          delivery.EventName,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		ResponseBody:   delivery.ResponseBody,
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		DeliveredAt:    delivery.DeliveredAt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+}
@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/queue"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+// Job permanently deleting a user once the grace period after they deleted their account is over
+const PurgeUserJob = "account.purge_user"
+
+// Deleted accounts can be restored for this many days, unless USER_DELETION_RETENTION_DAYS says otherwise
+const defaultUserDeletionRetentionDays = 30
+
+type PurgeUserPayload struct {
+	UserID uint `json:"userID"`
+}
+
+// Returns how long deleted accounts can be restored before they are purged.
+func UserDeletionGracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("USER_DELETION_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		days = defaultUserDeletionRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Queues the purge of a user for the end of the grace period, in the transaction that deletes them.
+func EnqueuePurgeUser(tx *gorm.DB, userID uint, deletedAt time.Time) error {
+	_, err := queue.Enqueue(tx, PurgeUserJob, PurgeUserPayload{UserID: userID}, queue.RunAt(deletedAt.Add(UserDeletionGracePeriod())))
+	return err
+}
+
+// Purges the user with everything they created, see models.PurgeUser, and removes their files once that committed.
+// Users restored in the meantime are left alone, and users whose grace period was extended get a new job.
+func handlePurgeUser(db *gorm.DB, store storage.BlobStore) {
+	queue.Handle(PurgeUserJob, func(ctx context.Context, payload PurgeUserPayload) error {
+		var user models.User
+		err := db.Unscoped().First(&user, payload.UserID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil || !user.DeletedAt.Valid {
+			return err
+		}
+		if time.Now().Before(user.DeletedAt.Time.Add(UserDeletionGracePeriod())) {
+			return EnqueuePurgeUser(db, user.ID, user.DeletedAt.Time)
+		}
+
+		var storageKeys []string
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			storageKeys, err = models.PurgeUser(tx, user.ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range storageKeys {
+			if err := store.Delete(ctx, key); err != nil {
+				log.Printf("Could not delete file %s of purged user %d: %v", key, user.ID, err)
+			}
+		}
+		log.Printf("Purged deleted user %d", user.ID)
+		return nil
+	})
+}
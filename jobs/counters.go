@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Recomputes the denormalized counters and repairs any that drifted.
+func reconcileCounters(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		repaired, err := models.ReconcileUserCounters(db)
+		if err != nil {
+			return err
+		}
+		if repaired > 0 {
+			log.Printf("Repaired drifted counters of %d users", repaired)
+		}
+
+		repaired, err = models.ReconcileCommentCounters(db)
+		if err == nil && repaired > 0 {
+			log.Printf("Repaired drifted comment counters of %d posts and comments", repaired)
+		}
+		return err
+	}
+}
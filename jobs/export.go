@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coaltail/GoOrders/export"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"github.com/coaltail/GoOrders/queue"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+// Job building the archive of a data export
+const BuildDataExportJob = "account.build_data_export"
+
+// Archives can be downloaded this long after they were built
+const DataExportLifetime = 7 * 24 * time.Hour
+
+// Exports that are still pending after this long are given up on
+const dataExportTimeout = 24 * time.Hour
+
+type BuildDataExportPayload struct {
+	ExportID uint `json:"exportID"`
+}
+
+// Queues the archive of a new data export, in the transaction that creates it.
+func EnqueueBuildDataExport(tx *gorm.DB, exportID uint) error {
+	_, err := queue.Enqueue(tx, BuildDataExportJob, BuildDataExportPayload{ExportID: exportID})
+	return err
+}
+
+func handleBuildDataExport(db *gorm.DB, store storage.BlobStore) {
+	queue.Handle(BuildDataExportJob, func(ctx context.Context, payload BuildDataExportPayload) error {
+		var dataExport models.DataExport
+		err := db.First(&dataExport, payload.ExportID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil || dataExport.Status != models.ExportPending {
+			return err
+		}
+		return buildDataExport(ctx, db, store, dataExport)
+	})
+}
+
+// Writes the archive to a temporary file first, so its size is known before it is uploaded, then marks the export
+// ready and notifies the user. Retries overwrite the archive of an earlier attempt, its key only depends on the export.
+func buildDataExport(ctx context.Context, db *gorm.DB, store storage.BlobStore, dataExport models.DataExport) error {
+	file, err := os.CreateTemp("", "data-export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := export.WriteArchive(ctx, db, store, dataExport.UserID, file); err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("exports/%d/%d.zip", dataExport.UserID, dataExport.ID)
+	if err := store.Put(ctx, key, file, size, "application/zip"); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(DataExportLifetime)
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&dataExport).Where("status = ?", models.ExportPending).Updates(map[string]interface{}{
+			"status":       models.ExportReady,
+			"storage_key":  key,
+			"size":         size,
+			"completed_at": now,
+			"expires_at":   expiresAt,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return notifications.NotifyAccount(tx, dataExport.UserID, models.NotificationDataExport, models.SubjectDataExport, dataExport.ID)
+	})
+}
+
+// Deletes the archives of exports past their download period and gives up on exports that were never built.
+func expireDataExports(db *gorm.DB, store storage.BlobStore) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		err := db.Model(&models.DataExport{}).Where("status = ? AND created_at < ?", models.ExportPending, time.Now().Add(-dataExportTimeout)).
+			Update("status", models.ExportFailed).Error
+		if err != nil {
+			return err
+		}
+
+		var expired []models.DataExport
+		if err := db.Where("status = ? AND expires_at < ?", models.ExportReady, time.Now()).Find(&expired).Error; err != nil {
+			return err
+		}
+		for _, dataExport := range expired {
+			if err := store.Delete(ctx, dataExport.StorageKey); err != nil {
+				return err
+			}
+			if err := db.Model(&dataExport).Updates(map[string]interface{}{"status": models.ExportExpired, "storage_key": ""}).Error; err != nil {
+				return err
+			}
+		}
+		if len(expired) > 0 {
+			log.Printf("Deleted %d expired data exports", len(expired))
+		}
+		return nil
+	}
+}
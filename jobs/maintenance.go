@@ -0,0 +1,21 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Deletes the login tokens that expired.
+func purgeExpiredTokens(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		result := db.Unscoped().Where("expires_at < ?", time.Now().Unix()).Delete(&models.Token{})
+		if result.Error == nil && result.RowsAffected > 0 {
+			log.Printf("Purged %d expired tokens", result.RowsAffected)
+		}
+		return result.Error
+	}
+}
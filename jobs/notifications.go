@@ -0,0 +1,25 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/coaltail/GoOrders/notifications"
+	"gorm.io/gorm"
+)
+
+// Number of deliveries claimed at once by the dispatcher
+const deliveryBatchSize = 50
+
+// Sends queued notification deliveries over their channels, retrying failed ones with backoff.
+func StartNotificationDispatcher(db *gorm.DB, interval time.Duration) {
+	dispatcher := &notifications.Dispatcher{DB: db, Channels: notifications.Channels}
+	runPeriodically("notification deliveries", interval, func() error {
+		for {
+			attempted, err := dispatcher.DeliverDue(context.Background(), deliveryBatchSize)
+			if err != nil || attempted < deliveryBatchSize {
+				return err
+			}
+		}
+	})
+}
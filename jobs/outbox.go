@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/coaltail/GoOrders/events"
+	"gorm.io/gorm"
+)
+
+// Number of outbox events claimed at once by the dispatcher
+const outboxBatchSize = 100
+
+// Processed outbox events are kept this long, which is plenty to look into what happened
+const outboxRetention = 7 * 24 * time.Hour
+
+// Delivers domain events from the outbox to their subscribers and purges old processed events.
+func StartOutboxDispatcher(db *gorm.DB, interval time.Duration) {
+	dispatcher := &events.Dispatcher{DB: db, Bus: events.DefaultBus}
+	runPeriodically("outbox dispatcher", interval, func() error {
+		for {
+			attempted, err := dispatcher.DispatchDue(context.Background(), outboxBatchSize)
+			if err != nil || attempted < outboxBatchSize {
+				return err
+			}
+		}
+	})
+	runPeriodically("outbox purge", time.Hour, func() error {
+		return events.PurgeProcessed(db, outboxRetention)
+	})
+}
@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// Runs task once right away and then every interval, in its own goroutine. Failures are logged and the task simply
+// runs again next time.
+func runPeriodically(name string, interval time.Duration, task func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := task(); err != nil {
+				log.Printf("Periodic task %s failed: %v", name, err)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/coaltail/GoOrders/queue"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+// Queue of the image processing jobs, which get their own workers so they cannot hold up everything else
+const MediaQueue = "media"
+
+// Succeeded jobs are kept this long, so the admin endpoints can still show them
+const jobRetention = 7 * 24 * time.Hour
+
+// Registers the job handlers and starts a worker pool for every queue. JOB_CONCURRENCY and JOB_MEDIA_CONCURRENCY
+// set how many jobs of the default and the media queue run at once.
+func StartJobWorkers(db *gorm.DB, store storage.BlobStore) {
+	handleGenerateVariants(db, store)
+	handleBuildDataExport(db, store)
+	handlePurgeUser(db, store)
+
+	pools := []*queue.Pool{
+		{DB: db, Queue: queue.DefaultQueue, Concurrency: envInt("JOB_CONCURRENCY", 4), PollInterval: time.Second},
+		{DB: db, Queue: MediaQueue, Concurrency: envInt("JOB_MEDIA_CONCURRENCY", 2), PollInterval: time.Second},
+	}
+	for _, pool := range pools {
+		pool.Start(context.Background())
+	}
+	runPeriodically("job purge", time.Hour, func() error {
+		return queue.PurgeSucceeded(db, jobRetention)
+	})
+}
+
+// Returns the positive integer in the environment variable, or fallback when it is unset or invalid.
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coaltail/GoOrders/scheduler"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+// The run history of scheduled tasks is kept this long
+const taskRunRetention = 30 * 24 * time.Hour
+
+// Starts the maintenance tasks that run on a schedule. Only one instance runs each of them, see the scheduler package.
+func StartScheduler(db *gorm.DB, store storage.BlobStore) {
+	s := scheduler.New(db)
+	tasks := []struct {
+		name     string
+		schedule string
+		run      func(ctx context.Context) error
+	}{
+		{"purge-expired-tokens", "*/30 * * * *", purgeExpiredTokens(db)},
+		{"compute-suggestions", "0 */6 * * *", computeSuggestions(db)},
+		{"reconcile-counters", "5 * * * *", reconcileCounters(db)},
+		{"expire-data-exports", "20 * * * *", expireDataExports(db, store)},
+		{"purge-task-runs", "45 3 * * *", func(ctx context.Context) error {
+			return scheduler.PurgeRuns(db, taskRunRetention)
+		}},
+	}
+	for _, task := range tasks {
+		if err := s.Add(task.name, task.schedule, task.run); err != nil {
+			log.Fatalf("Invalid schedule of task %s: %v", task.name, err)
+		}
+	}
+	s.Start(context.Background())
+}
@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/coaltail/GoOrders/stream"
+	"gorm.io/gorm"
+)
+
+// Logs of users that have not been connected for this long are dropped, so they cannot resume anymore
+const streamLogRetention = 15 * time.Minute
+
+// Publishes committed notifications to the event stream and drops event logs nobody reads anymore.
+func StartStreamPublisher(db *gorm.DB, interval time.Duration) {
+	tail := &stream.NotificationTail{DB: db, Broker: stream.Default}
+	runPeriodically("stream publisher", interval, func() error {
+		stream.Default.Prune(streamLogRetention)
+		return tail.Poll()
+	})
+}
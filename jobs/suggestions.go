@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Rebuilds the "people you may know" suggestions, so serving them is a lookup.
+func computeSuggestions(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		stored, err := models.ComputeSuggestions(db)
+		if err == nil {
+			log.Printf("Computed %d user suggestions", stored)
+		}
+		return err
+	}
+}
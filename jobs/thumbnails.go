@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coaltail/GoOrders/media"
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/queue"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+)
+
+// Job generating the resized variants of an uploaded image
+const GenerateVariantsJob = "media.generate_variants"
+
+type GenerateVariantsPayload struct {
+	AttachmentID uint `json:"attachmentID"`
+}
+
+// Queues variant generation for a new image attachment, in the transaction that creates it.
+func EnqueueGenerateVariants(tx *gorm.DB, attachmentID uint) error {
+	_, err := queue.Enqueue(tx, GenerateVariantsJob, GenerateVariantsPayload{AttachmentID: attachmentID}, queue.OnQueue(MediaQueue))
+	return err
+}
+
+func handleGenerateVariants(db *gorm.DB, store storage.BlobStore) {
+	queue.Handle(GenerateVariantsJob, func(ctx context.Context, payload GenerateVariantsPayload) error {
+		var attachment models.Attachment
+		err := db.First(&attachment, payload.AttachmentID).Error
+		// Attachments deleted in the meantime, or processed by an earlier run, need nothing anymore
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil || attachment.ProcessedAt != nil {
+			return err
+		}
+		return media.GenerateVariants(ctx, db, store, attachment)
+	})
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/coaltail/GoOrders/safehttp"
+	"github.com/coaltail/GoOrders/webhooks"
+	"gorm.io/gorm"
+)
+
+// Number of webhook deliveries claimed at once by the dispatcher
+const webhookBatchSize = 50
+
+// Posts queued webhook deliveries, retrying failed ones with backoff.
+func StartWebhookDispatcher(db *gorm.DB, interval time.Duration) {
+	dispatcher := &webhooks.Dispatcher{DB: db, Client: safehttp.NewClient(15 * time.Second)}
+	runPeriodically("webhook deliveries", interval, func() error {
+		for {
+			attempted, err := dispatcher.DeliverDue(context.Background(), webhookBatchSize)
+			if err != nil || attempted < webhookBatchSize {
+				return err
+			}
+		}
+	})
+}
@@ -1,14 +1,33 @@
 package main
 
 import (
+	"time"
+
 	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/events"
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/jobs"
+	"github.com/coaltail/GoOrders/notifications"
 	"github.com/coaltail/GoOrders/routes"
+	"github.com/coaltail/GoOrders/storage"
+	"github.com/coaltail/GoOrders/webhooks"
 	"github.com/gofiber/fiber/v2"
 )
 
 func main() {
 	database.ConnectDb()
-	app := fiber.New()
+	storage.Setup()
+	notifications.SetupChannels()
+	jobs.StartJobWorkers(database.DB.Db, storage.Store)
+	jobs.StartScheduler(database.DB.Db, storage.Store)
+	jobs.StartNotificationDispatcher(database.DB.Db, 5*time.Second)
+	jobs.StartStreamPublisher(database.DB.Db, time.Second)
+	webhooks.Register(events.DefaultBus, database.DB.Db)
+	jobs.StartOutboxDispatcher(database.DB.Db, time.Second)
+	jobs.StartWebhookDispatcher(database.DB.Db, 5*time.Second)
+	app := fiber.New(fiber.Config{
+		BodyLimit: handlers.MediaBodyLimit(),
+	})
 
 	// Set up your routes after
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -17,6 +36,15 @@ func main() {
 		})
 	})
 	routes.SetupUserRoutes(app)
+	routes.SetupSearchRoutes(app)
+	routes.SetupPostRoutes(app)
+	routes.SetupMessageRoutes(app)
+	routes.SetupMediaRoutes(app)
+	routes.SetupGroupRoutes(app)
+	routes.SetupNotificationRoutes(app)
+	routes.SetupStreamRoutes(app)
+	routes.SetupWebhookRoutes(app)
+	routes.SetupAdminRoutes(app)
 
 	// Start your Fiber app
 	app.Listen(":3000")
@@ -0,0 +1,257 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedImage = errors.New("malformed image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG chunks that can carry EXIF or XMP metadata, location included
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+}
+
+// WebP chunks holding EXIF and XMP metadata, and the VP8X flags announcing them
+const (
+	webpEXIFChunk = "EXIF"
+	webpXMPChunk  = "XMP "
+	webpEXIFFlag  = 0x08
+	webpXMPFlag   = 0x04
+)
+
+// The StripMetadata function removes EXIF, XMP and IPTC metadata, which can contain the location a photo was taken at,
+// from JPEG, PNG and WebP files without re-encoding them. JPEGs and WebPs keep their EXIF orientation, so they are
+// still displayed the right way up, and it is returned so the caller can account for it. Other content types are
+// returned unchanged.
+func StripMetadata(contentType string, data []byte) ([]byte, int, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		stripped, err := stripPNGMetadata(data)
+		return stripped, 1, err
+	case "image/webp":
+		return stripWebPMetadata(data)
+	default:
+		return data, 1, nil
+	}
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errMalformedImage
+	}
+
+	orientation := 1
+	var segments [][]byte
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, 0, errMalformedImage
+		}
+		marker := data[pos+1]
+		// Fill bytes before a marker
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errMalformedImage
+		}
+		segment := data[pos:end]
+		payload := segment[4:]
+
+		switch {
+		case marker == 0xDA:
+			// Start of scan, everything from here on is image data
+			segments = append(segments, data[pos:])
+			return joinJPEG(segments, orientation), orientation, nil
+		case marker == 0xE1:
+			// APP1 holds EXIF and XMP, only the orientation is kept
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				if value, ok := exifOrientation(payload[6:]); ok {
+					orientation = value
+				}
+			}
+		case marker == 0xED:
+			// APP13 holds Photoshop and IPTC data
+		default:
+			segments = append(segments, segment)
+		}
+		pos = end
+	}
+}
+
+// Rebuilds a JPEG from its segments, with a minimal EXIF segment holding only the orientation when it is not the
+// default one.
+func joinJPEG(segments [][]byte, orientation int) []byte {
+	var buffer bytes.Buffer
+	buffer.Write([]byte{0xFF, 0xD8})
+	for i, segment := range segments {
+		// The EXIF segment has to follow the JFIF segment, if there is one
+		if i == 0 && orientation > 1 && !bytes.HasPrefix(segment, []byte{0xFF, 0xE0}) {
+			buffer.Write(orientationSegment(orientation))
+		}
+		buffer.Write(segment)
+		if i == 0 && orientation > 1 && bytes.HasPrefix(segment, []byte{0xFF, 0xE0}) {
+			buffer.Write(orientationSegment(orientation))
+		}
+	}
+	return buffer.Bytes()
+}
+
+// Returns an APP1 segment with an EXIF block that only contains the orientation tag.
+func orientationSegment(orientation int) []byte {
+	exif := append([]byte("Exif\x00\x00"), orientationTIFF(orientation)...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	return append(segment, exif...)
+}
+
+// Returns a TIFF structured EXIF block that only contains the orientation tag.
+func orientationTIFF(orientation int) []byte {
+	return []byte{
+		// Big endian TIFF header, IFD0 at offset 8
+		'M', 'M', 0, 42, 0, 0, 0, 8,
+		// One entry: tag 0x0112 (orientation), type 3 (short), count 1, value
+		0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0,
+		// No next IFD
+		0, 0, 0, 0,
+	}
+}
+
+// Reads the orientation tag from IFD0 of a TIFF structured EXIF block.
+func exifOrientation(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 0, false
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value < 1 || value > 8 {
+				return 0, false
+			}
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// Drops the metadata chunks of a PNG. The other chunks, and with them their checksums, are copied as they are.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedImage
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(pngSignature)
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformedImage
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			buffer.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return buffer.Bytes(), nil
+}
+
+// Drops the EXIF and XMP chunks of a WebP and clears their flags in the VP8X chunk. An orientation other than the
+// default one is kept in a minimal EXIF chunk at the end of the file, where the format places EXIF data.
+func stripWebPMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, errMalformedImage
+	}
+	// Anything after the RIFF chunk is not part of the image
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || 8+size > len(data) {
+		return nil, 0, errMalformedImage
+	}
+	data = data[:8+size]
+
+	orientation := 1
+	vp8x := -1
+	var buffer bytes.Buffer
+	buffer.Write(data[:12])
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, 0, errMalformedImage
+		}
+		fourCC := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		// Chunks are padded to an even length
+		end := pos + 8 + length + length%2
+		if length < 0 || end > len(data) {
+			return nil, 0, errMalformedImage
+		}
+		switch fourCC {
+		case webpEXIFChunk:
+			payload := bytes.TrimPrefix(data[pos+8:pos+8+length], []byte("Exif\x00\x00"))
+			if value, ok := exifOrientation(payload); ok {
+				orientation = value
+			}
+		case webpXMPChunk:
+		default:
+			if fourCC == "VP8X" && length >= 1 {
+				vp8x = buffer.Len() + 8
+			}
+			buffer.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	stripped := buffer.Bytes()
+	if vp8x >= 0 {
+		stripped[vp8x] &^= webpEXIFFlag | webpXMPFlag
+		// Only the extended format can carry an EXIF chunk
+		if orientation > 1 {
+			stripped[vp8x] |= webpEXIFFlag
+			exif := orientationTIFF(orientation)
+			chunk := make([]byte, 8, 8+len(exif))
+			copy(chunk, webpEXIFChunk)
+			binary.LittleEndian.PutUint32(chunk[4:], uint32(len(exif)))
+			stripped = append(stripped, append(chunk, exif...)...)
+		}
+	} else {
+		orientation = 1
+	}
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, orientation, nil
+}
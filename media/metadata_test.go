@@ -0,0 +1,112 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Builds a WebP file out of chunks given as alternating FourCCs and payloads.
+func webpFile(chunks ...string) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for i := 0; i < len(chunks); i += 2 {
+		body.WriteString(chunks[i])
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunks[i+1])))
+		body.WriteString(chunks[i+1])
+		if len(chunks[i+1])%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	file := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(file[4:], uint32(body.Len()))
+	return append(file, body.Bytes()...)
+}
+
+// Returns the FourCCs of the chunks of a WebP file.
+func webpChunks(t *testing.T, data []byte) []string {
+	t.Helper()
+	if size := int(binary.LittleEndian.Uint32(data[4:])); size != len(data)-8 {
+		t.Fatalf("RIFF size = %d, want %d", size, len(data)-8)
+	}
+	var fourCCs []string
+	for pos := 12; pos < len(data); {
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		fourCCs = append(fourCCs, string(data[pos:pos+4]))
+		pos += 8 + length + length%2
+	}
+	return fourCCs
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	// EXIF with the orientation tag set to 6, in little endian order this time
+	exif := "II\x2a\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00"
+	vp8x := "\x0c\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	tests := []struct {
+		name        string
+		data        []byte
+		chunks      []string
+		orientation int
+		flags       byte
+	}{
+		{
+			name:        "extended with orientation",
+			data:        webpFile("VP8X", vp8x, "VP8 ", "image", "EXIF", exif, "XMP ", "<x:xmpmeta/>?"),
+			chunks:      []string{"VP8X", "VP8 ", "EXIF"},
+			orientation: 6,
+			flags:       webpEXIFFlag,
+		},
+		{
+			name:        "extended with Exif prefix and default orientation",
+			data:        webpFile("VP8X", vp8x, "VP8L", "image", "EXIF", "Exif\x00\x00"+exif[:18]+"\x01"+exif[19:], "XMP ", "<x:xmpmeta/>"),
+			chunks:      []string{"VP8X", "VP8L"},
+			orientation: 1,
+		},
+		{
+			name:        "simple",
+			data:        webpFile("VP8 ", "image"),
+			chunks:      []string{"VP8 "},
+			orientation: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stripped, orientation, err := StripMetadata("image/webp", tt.data)
+			if err != nil {
+				t.Fatalf("StripMetadata() error = %v", err)
+			}
+			if orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", orientation, tt.orientation)
+			}
+			chunks := webpChunks(t, stripped)
+			if len(chunks) != len(tt.chunks) {
+				t.Fatalf("chunks = %q, want %q", chunks, tt.chunks)
+			}
+			for i := range chunks {
+				if chunks[i] != tt.chunks[i] {
+					t.Fatalf("chunks = %q, want %q", chunks, tt.chunks)
+				}
+			}
+			if chunks[0] == "VP8X" && stripped[20] != tt.flags {
+				t.Errorf("VP8X flags = %#x, want %#x", stripped[20], tt.flags)
+			}
+			if bytes.Contains(stripped, []byte("xmpmeta")) {
+				t.Error("XMP data was kept")
+			}
+		})
+	}
+}
+
+func TestStripWebPMetadataMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("RIFF"),
+		[]byte("RIFF\x04\x00\x00\x00WEBX"),
+		[]byte("RIFF\x20\x00\x00\x00WEBP"),
+		webpFile("VP8 ", "image")[:18],
+	} {
+		if _, _, err := StripMetadata("image/webp", data); err != errMalformedImage {
+			t.Errorf("StripMetadata(%q) error = %v, want %v", data, err, errMalformedImage)
+		}
+	}
+}
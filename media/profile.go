@@ -0,0 +1,85 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+// ProfileImageSpec describes the shape profile images are cropped and resized to.
+type ProfileImageSpec struct {
+	Width  int
+	Height int
+	// Smaller uploads are rejected, they would look blurry
+	MinWidth  int
+	MinHeight int
+}
+
+var (
+	AvatarSpec = ProfileImageSpec{Width: 400, Height: 400, MinWidth: 100, MinHeight: 100}
+	CoverSpec  = ProfileImageSpec{Width: 1500, Height: 500, MinWidth: 600, MinHeight: 200}
+)
+
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// The ProcessProfileImage function turns an uploaded avatar or cover into its final form: rotated upright, cropped
+// to the aspect ratio of the spec around the center and scaled down to the spec's size. The result is re-encoded,
+// so none of the original metadata survives, and its content type and file extension are returned with it.
+func ProcessProfileImage(contentType string, data []byte, spec ProfileImageSpec) ([]byte, string, string, error) {
+	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif" {
+		return nil, "", "", ErrUnsupportedImage
+	}
+	_, orientation, err := StripMetadata(contentType, data)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		return nil, "", "", fmt.Errorf("images may have at most %d pixels", maxDecodePixels)
+	}
+	width, height := OrientedSize(config.Width, config.Height, orientation)
+	if width < spec.MinWidth || height < spec.MinHeight {
+		return nil, "", "", fmt.Errorf("images must be at least %dx%d pixels", spec.MinWidth, spec.MinHeight)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", err
+	}
+	upright := orient(toRGBA(img), orientation)
+	cropped := upright.SubImage(centerCrop(upright.Bounds(), spec.Width, spec.Height))
+	targetWidth, targetHeight := spec.Width, spec.Height
+	if cropped.Bounds().Dx() < targetWidth {
+		// Images between the minimum and the target size are only cropped, never scaled up
+		targetWidth, targetHeight = cropped.Bounds().Dx(), cropped.Bounds().Dy()
+	}
+	result := resize(cropped, targetWidth, targetHeight)
+
+	var buffer bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buffer, result, &jpeg.Options{Quality: jpegQuality})
+		return buffer.Bytes(), "image/jpeg", ".jpg", err
+	}
+	err = png.Encode(&buffer, result)
+	return buffer.Bytes(), "image/png", ".png", err
+}
+
+// Returns the largest rectangle with the aspect ratio width:height centered in bounds.
+func centerCrop(bounds image.Rectangle, width int, height int) image.Rectangle {
+	cropWidth, cropHeight := bounds.Dx(), bounds.Dy()
+	if cropWidth*height > cropHeight*width {
+		cropWidth = cropHeight * width / height
+	} else {
+		cropHeight = cropWidth * height / width
+	}
+	x := bounds.Min.X + (bounds.Dx()-cropWidth)/2
+	y := bounds.Min.Y + (bounds.Dy()-cropHeight)/2
+	return image.Rect(x, y, x+cropWidth, y+cropHeight)
+}
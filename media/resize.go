@@ -0,0 +1,145 @@
+package media
+
+import (
+	"image"
+	"image/draw"
+)
+
+type contribution struct {
+	index  int
+	weight float32
+}
+
+// Returns, for every destination pixel along one axis, the source pixels it covers and how much of each, so that
+// downscaling averages over the whole area instead of sampling single pixels.
+func areaWeights(srcSize int, dstSize int) [][]contribution {
+	scale := float64(srcSize) / float64(dstSize)
+	weights := make([][]contribution, dstSize)
+	for d := 0; d < dstSize; d++ {
+		start := float64(d) * scale
+		end := start + scale
+		var total float64
+		for s := int(start); s < srcSize && float64(s) < end; s++ {
+			covered := min(end, float64(s+1)) - max(start, float64(s))
+			if covered <= 0 {
+				continue
+			}
+			weights[d] = append(weights[d], contribution{index: s, weight: float32(covered)})
+			total += covered
+		}
+		for i := range weights[d] {
+			weights[d][i].weight /= float32(total)
+		}
+	}
+	return weights
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+// Scales the image down to width x height with area averaging, in two separable passes. Colors are averaged in
+// premultiplied form, so transparent pixels do not bleed into their neighbours.
+func resize(src image.Image, width int, height int) *image.RGBA {
+	rgba := toRGBA(src)
+	srcWidth, srcHeight := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+
+	horizontal := areaWeights(srcWidth, width)
+	tmp := make([]float32, width*srcHeight*4)
+	for y := 0; y < srcHeight; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x, contributions := range horizontal {
+			var r, g, b, a float32
+			for _, c := range contributions {
+				pixel := row[c.index*4:]
+				r += float32(pixel[0]) * c.weight
+				g += float32(pixel[1]) * c.weight
+				b += float32(pixel[2]) * c.weight
+				a += float32(pixel[3]) * c.weight
+			}
+			i := (y*width + x) * 4
+			tmp[i], tmp[i+1], tmp[i+2], tmp[i+3] = r, g, b, a
+		}
+	}
+
+	vertical := areaWeights(srcHeight, height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, contributions := range vertical {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float32
+			for _, c := range contributions {
+				i := (c.index*width + x) * 4
+				r += tmp[i] * c.weight
+				g += tmp[i+1] * c.weight
+				b += tmp[i+2] * c.weight
+				a += tmp[i+3] * c.weight
+			}
+			pixel := dst.Pix[y*dst.Stride+x*4:]
+			pixel[0], pixel[1], pixel[2], pixel[3] = clampUint8(r), clampUint8(g), clampUint8(b), clampUint8(a)
+		}
+	}
+	return dst
+}
+
+func clampUint8(value float32) uint8 {
+	if value <= 0 {
+		return 0
+	}
+	if value >= 255 {
+		return 255
+	}
+	return uint8(value + 0.5)
+}
+
+// Rotates and flips the image according to an EXIF orientation value, so that it is displayed the right way up
+// without the orientation tag.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:])
+		}
+	}
+	return dst
+}
+
+// Returns the dimensions an image is displayed with, which are swapped for the orientations that rotate it by 90
+// degrees.
+func OrientedSize(width int, height int, orientation int) (int, int) {
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
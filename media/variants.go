@@ -0,0 +1,136 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type VariantSpec struct {
+	Name string
+	// Variants fit in a square of this size
+	MaxSize int
+}
+
+var VariantSpecs = []VariantSpec{
+	{Name: "small", MaxSize: 160},
+	{Name: "medium", MaxSize: 640},
+	{Name: "large", MaxSize: 1280},
+}
+
+// Images with more pixels than this are not decoded, so a small file that decompresses to a huge image cannot
+// exhaust the memory of the worker.
+const maxDecodePixels = 50_000_000
+
+const jpegQuality = 85
+
+// The GenerateVariants function creates every variant of an image attachment that is smaller than the original and
+// marks the attachment as processed. Variants are re-encoded, so they carry no metadata at all.
+func GenerateVariants(ctx context.Context, db *gorm.DB, store storage.BlobStore, attachment models.Attachment) error {
+	body, err := store.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		log.Printf("Not generating variants of attachment %d, the image is %dx%d", attachment.ID, config.Width, config.Height)
+		return markProcessed(db, attachment)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	// Metadata was stripped on upload, but JPEGs keep their orientation
+	_, orientation, err := StripMetadata(attachment.ContentType, data)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range VariantSpecs {
+		width, height, ok := fitWithin(config.Width, config.Height, spec.MaxSize)
+		if !ok {
+			continue
+		}
+		variant, err := storeVariant(ctx, store, attachment, spec, orient(resize(img, width, height), orientation))
+		if err != nil {
+			return err
+		}
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "attachment_id"}, {Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"storage_key", "content_type", "size", "width", "height"}),
+		}).Create(&variant).Error
+		if err != nil {
+			return err
+		}
+	}
+	return markProcessed(db, attachment)
+}
+
+// Returns the size of an image scaled down to fit in a maxSize square, or false if it already fits.
+func fitWithin(width int, height int, maxSize int) (int, int, bool) {
+	if width <= maxSize && height <= maxSize {
+		return 0, 0, false
+	}
+	if width >= height {
+		return maxSize, max(1, height*maxSize/width), true
+	}
+	return max(1, width*maxSize/height), maxSize, true
+}
+
+// Encodes and uploads a variant. Photos are stored as JPEG, everything else as PNG to keep transparency.
+func storeVariant(ctx context.Context, store storage.BlobStore, attachment models.Attachment, spec VariantSpec, img *image.RGBA) (models.AttachmentVariant, error) {
+	var buffer bytes.Buffer
+	contentType, extension := "image/png", ".png"
+	var err error
+	if attachment.ContentType == "image/jpeg" {
+		contentType, extension = "image/jpeg", ".jpg"
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buffer, img)
+	}
+	if err != nil {
+		return models.AttachmentVariant{}, err
+	}
+
+	key := fmt.Sprintf("%s_%s%s", strings.TrimSuffix(attachment.StorageKey, path.Ext(attachment.StorageKey)), spec.Name, extension)
+	size := int64(buffer.Len())
+	if err := store.Put(ctx, key, &buffer, size, contentType); err != nil {
+		return models.AttachmentVariant{}, err
+	}
+	return models.AttachmentVariant{
+		AttachmentID: attachment.ID,
+		Name:         spec.Name,
+		StorageKey:   key,
+		ContentType:  contentType,
+		Size:         size,
+		Width:        img.Bounds().Dx(),
+		Height:       img.Bounds().Dy(),
+	}, nil
+}
+
+func markProcessed(db *gorm.DB, attachment models.Attachment) error {
+	return db.Model(&attachment).Update("processed_at", time.Now()).Error
+}
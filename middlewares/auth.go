@@ -5,7 +5,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/coaltail/GoOrders/database"
+	"github.com/coaltail/GoOrders/models"
 	jwtware "github.com/gofiber/contrib/jwt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -13,10 +16,52 @@ import (
 
 func NewAuthMiddleware(secret string) fiber.Handler {
 	return jwtware.New(jwtware.Config{
-		SigningKey: jwtware.SigningKey{Key: []byte(secret)},
+		SigningKey:     jwtware.SigningKey{Key: []byte(secret)},
+		SuccessHandler: requireValidSession,
 	})
 }
 
+// Rejects tokens that are correctly signed but belong to a deleted user or were issued before the user's sessions
+// were revoked. Tokens without an issue time are treated as issued before any revocation.
+func requireValidSession(c *fiber.Ctx) error {
+	token, ok := c.Locals("user").(*jwt.Token)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid JWT or ID")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid JWT or ID")
+	}
+	userID, ok := claims["ID"].(float64)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid JWT or ID")
+	}
+	var issuedAt time.Time
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		issuedAt = iat.Time
+	}
+
+	valid, err := models.ValidSession(database.DB.Db, uint(userID), issuedAt)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Could not check session")
+	}
+	if !valid {
+		return fiber.NewError(fiber.StatusUnauthorized, "This session was revoked, please log in again.")
+	}
+	return c.Next()
+}
+
+// The TokenFromQuery middleware lets clients that cannot set headers pass their JWT in a query parameter instead. It has
+// to run before the auth middleware, and the Authorization header takes precedence.
+func TokenFromQuery(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token := c.Query(param); token != "" && c.Get(fiber.HeaderAuthorization) == "" {
+			c.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+		return c.Next()
+	}
+}
+
 func CompareJWTandUserIDMiddleware() func(*fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		// Get the user ID from the route parameters or wherever it is available
@@ -34,14 +79,9 @@ func CompareJWTandUserIDMiddleware() func(*fiber.Ctx) error {
 }
 
 func ParseAndCompare(userID int, c *fiber.Ctx) error {
-	claims, valid := ExtractClaims(c.Get("Authorization"))
-	if !valid {
-		return fiber.NewError(fiber.StatusInternalServerError, "Could not parse token")
-	}
-
-	userIDFromJWT, ok := claims["ID"].(float64)
-	if !ok {
-		return fiber.NewError(fiber.StatusUnauthorized, "Invalid JWT or ID")
+	userIDFromJWT, err := GetUserIDFromJWT(c)
+	if err != nil {
+		return err
 	}
 
 	// Data types have to be matching, convert both source and jwt id to int
@@ -53,6 +93,20 @@ func ParseAndCompare(userID int, c *fiber.Ctx) error {
 	return nil
 }
 
+// The GetUserIDFromJWT function returns the ID of the user making the request, as stored in the JWT claims.
+func GetUserIDFromJWT(c *fiber.Ctx) (uint, error) {
+	claims, valid := ExtractClaims(c.Get("Authorization"))
+	if !valid {
+		return 0, fiber.NewError(fiber.StatusInternalServerError, "Could not parse token")
+	}
+
+	userIDFromJWT, ok := claims["ID"].(float64)
+	if !ok {
+		return 0, fiber.NewError(fiber.StatusUnauthorized, "Invalid JWT or ID")
+	}
+	return uint(userIDFromJWT), nil
+}
+
 // The ExtractClaims function takes in a JWT token string and extracts its claims. The claims included are: ID, user email and token expiry time.
 func ExtractClaims(tokenStr string) (jwt.MapClaims, bool) {
 	// Remove the "Bearer " prefix if it exists
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Values of User.Status. Active is zero so that users created before deactivation existed stay active.
+const (
+	UserStatusActive = iota
+	// Deactivated users are hidden from everyone until they log in again
+	UserStatusDeactivated
+)
+
+// Column of User holding its status, it is never written from request bodies
+const UserStatusColumn = "status"
+
+// ActiveUsers is a scope for queries on users that leaves out deactivated accounts.
+func ActiveUsers(db *gorm.DB) *gorm.DB {
+	return db.Where("users.status = ?", UserStatusActive)
+}
+
+// Returns a subquery selecting every deactivated user, for use in "NOT IN (?)" conditions. Users who deleted their
+// account are included as well: their content stays in place until the purge job runs at the end of the grace period.
+func DeactivatedUserIDs(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Model(&User{}).Select("id").Where("status = ? OR deleted_at IS NOT NULL", UserStatusDeactivated)
+}
+
+// Returns a subquery selecting every user whose content is hidden from userID: the users blocked in either direction,
+// see BlockedUserIDs, and the users who deactivated or deleted their account.
+func HiddenUserIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Raw("(?) UNION (?)", BlockedUserIDs(db, userID), DeactivatedUserIDs(db))
+}
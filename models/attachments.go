@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// The most attachments a single post or message may have
+const MaxAttachments = 10
+
+// Columns of Attachment that link it to what it is attached to
+const (
+	AttachmentPostColumn         = "post_id"
+	AttachmentMessageColumn      = "message_id"
+	AttachmentGroupMessageColumn = "group_message_id"
+)
+
+var ErrInvalidAttachments = errors.New("attachments do not exist, belong to someone else or are already attached")
+
+// Links the given attachments of the owner to a post, message or group message, depending on column. Only attachments
+// that the owner uploaded and did not attach anywhere yet can be used, otherwise ErrInvalidAttachments is returned and
+// the transaction should be rolled back.
+func ClaimAttachments(tx *gorm.DB, ownerID uint, attachmentIDs []uint, column string, targetID uint) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	unique := map[uint]bool{}
+	for _, id := range attachmentIDs {
+		unique[id] = true
+	}
+
+	result := tx.Model(&Attachment{}).
+		Where("id IN ? AND owner_id = ?", attachmentIDs, ownerID).
+		Where("post_id IS NULL AND message_id IS NULL AND group_message_id IS NULL").
+		Update(column, targetID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(unique)) {
+		return ErrInvalidAttachments
+	}
+	return nil
+}
+
+// Content types variants can be generated for. WebP is left out, the standard library cannot decode it.
+var ThumbnailContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// Columns of User holding the storage keys of the profile images
+const (
+	AvatarKeyColumn = "avatar_key"
+	CoverKeyColumn  = "cover_key"
+)
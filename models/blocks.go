@@ -0,0 +1,44 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Returns a subquery selecting every user that blocked userID or was blocked by them. Blocks hide content in both
+// directions, so the result can be used directly in "NOT IN (?)" conditions.
+func BlockedUserIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&UserBlock{}).
+		Select("CASE WHEN source_id = ? THEN target_id ELSE source_id END", userID).
+		Where("(source_id = ? OR target_id = ?) AND type = ?", userID, userID, RestrictionBlock)
+}
+
+// Returns a subquery selecting every user that userID muted.
+func MutedUserIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&UserBlock{}).Select("target_id").Where("source_id = ? AND type = ?", userID, RestrictionMute)
+}
+
+// Returns a subquery selecting every user that userID follows with an approved follow.
+func FollowingIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&UserFollower{}).Select("target_id").Where("source_id = ? AND type = ?", userID, FollowTypeActive)
+}
+
+// Returns a subquery selecting every user that follows userID with an approved follow.
+func FollowerIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&UserFollower{}).Select("source_id").Where("target_id = ? AND type = ?", userID, FollowTypeActive)
+}
+
+// Returns a subquery selecting every accepted friend of userID, whichever of the two sent the request.
+func FriendIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&UserFriend{}).
+		Select("CASE WHEN source_id = ? THEN target_id ELSE source_id END", userID).
+		Where("(source_id = ? OR target_id = ?) AND status = ?", userID, userID, FriendStatusAccepted)
+}
+
+// Reports whether either of the two users blocked the other.
+func IsBlocked(db *gorm.DB, userID uint, otherID uint) (bool, error) {
+	var count int64
+	err := db.Model(&UserBlock{}).
+		Where("((source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)) AND type = ?", userID, otherID, otherID, userID, RestrictionBlock).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// Denormalized counter columns on the users table. They are updated in the same transaction as the rows they count
+// and repaired by ReconcileUserCounters if they ever drift.
+const (
+	FollowerCountColumn  = "follower_count"
+	FollowingCountColumn = "following_count"
+	FriendCountColumn    = "friend_count"
+	PostCountColumn      = "post_count"
+)
+
+// Adds delta to one of the counter columns of a user, never letting it go below zero.
+func IncrementUserCounter(tx *gorm.DB, userID uint, column string, delta int) error {
+	return tx.Model(&User{}).Where("id = ?", userID).
+		UpdateColumn(column, gorm.Expr("GREATEST("+column+" + ?, 0)", delta)).Error
+}
+
+// Name of the denormalized comment counter on the posts table
+const CommentCountColumn = "comment_count"
+
+// Adds delta to the comment counter of a post, never letting it go below zero.
+func IncrementPostCommentCount(tx *gorm.DB, postID uint, delta int) error {
+	return tx.Model(&Post{}).Where("id = ?", postID).
+		UpdateColumn(CommentCountColumn, gorm.Expr("GREATEST("+CommentCountColumn+" + ?, 0)", delta)).Error
+}
+
+// Recounts the comments of every post and every top-level comment's replies, fixing the ones that drifted.
+// Returns the number of rows that were repaired.
+func ReconcileCommentCounters(db *gorm.DB) (int64, error) {
+	posts := db.Exec(`
+UPDATE posts SET comment_count = counts.comment_count
+FROM (
+	SELECT p.id, (SELECT count(*) FROM post_comments pc WHERE pc.post_id = p.id AND pc.deleted_at IS NULL) AS comment_count
+	FROM posts p
+) AS counts
+WHERE posts.id = counts.id AND posts.comment_count <> counts.comment_count`)
+	if posts.Error != nil {
+		return posts.RowsAffected, posts.Error
+	}
+
+	comments := db.Exec(`
+UPDATE post_comments SET reply_count = counts.reply_count
+FROM (
+	SELECT c.id, (SELECT count(*) FROM post_comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL) AS reply_count
+	FROM post_comments c WHERE c.parent_id IS NULL
+) AS counts
+WHERE post_comments.id = counts.id AND post_comments.reply_count <> counts.reply_count`)
+	return posts.RowsAffected + comments.RowsAffected, comments.Error
+}
+
+// Recounts every counter column from the underlying tables and fixes the users whose stored values drifted.
+// Returns the number of users that were repaired.
+func ReconcileUserCounters(db *gorm.DB) (int64, error) {
+	result := db.Exec(`
+UPDATE users SET
+	follower_count = counts.follower_count,
+	following_count = counts.following_count,
+	friend_count = counts.friend_count,
+	post_count = counts.post_count
+FROM (
+	SELECT u.id,
+		(SELECT count(*) FROM user_followers f WHERE f.target_id = u.id AND f.type = @active AND f.deleted_at IS NULL) AS follower_count,
+		(SELECT count(*) FROM user_followers f WHERE f.source_id = u.id AND f.type = @active AND f.deleted_at IS NULL) AS following_count,
+		(SELECT count(*) FROM user_friends f WHERE (f.source_id = u.id OR f.target_id = u.id) AND f.status = @accepted AND f.deleted_at IS NULL) AS friend_count,
+		(SELECT count(*) FROM posts p WHERE p.user_id = u.id AND p.deleted_at IS NULL) AS post_count
+	FROM users u
+) AS counts
+WHERE users.id = counts.id AND
+	(users.follower_count, users.following_count, users.friend_count, users.post_count) IS DISTINCT FROM
+	(counts.follower_count, counts.following_count, counts.friend_count, counts.post_count)`,
+		sql.Named("active", FollowTypeActive), sql.Named("accepted", FriendStatusAccepted))
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,11 @@
+package models
+
+// Statuses of a DataExport
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	// Exports that were not built in time, the user can request a new one
+	ExportFailed = "failed"
+	// Ready exports whose archive was deleted after the download period
+	ExportExpired = "expired"
+)
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSeparationHops = 3
+	// Every extra hop multiplies the number of paths explored, so searches deeper than this are refused
+	MaxSeparationHops = 4
+	// Upper bound on the time a single separation search may run, whatever the shape of the graph
+	separationStatementTimeout = "2s"
+)
+
+// Walks the accepted friendships breadth first, one hop per iteration, never revisiting a user already on the current
+// path. Iterations run level by level, so the first row reaching the target is a shortest path and LIMIT 1 lets
+// Postgres stop there instead of exploring the rest of the graph. Paths never lead through users hidden from the
+// source: those blocked in either direction, deactivated and deleted users.
+const separationQuery = `
+WITH RECURSIVE hidden(user_id) AS (
+	SELECT CASE WHEN source_id = @source THEN target_id ELSE source_id END FROM user_blocks
+	WHERE (source_id = @source OR target_id = @source) AND type = @block AND deleted_at IS NULL
+	UNION
+	SELECT id FROM users WHERE status = @deactivated OR deleted_at IS NOT NULL
+), walk(user_id, depth, path) AS (
+	SELECT CAST(@source AS bigint), 0, ARRAY[CAST(@source AS bigint)]
+	UNION ALL
+	SELECT CASE WHEN f.source_id = w.user_id THEN f.target_id ELSE f.source_id END, w.depth + 1,
+		w.path || CASE WHEN f.source_id = w.user_id THEN f.target_id ELSE f.source_id END
+	FROM walk w
+	JOIN user_friends f ON (f.source_id = w.user_id OR f.target_id = w.user_id) AND f.status = @accepted AND f.deleted_at IS NULL
+	WHERE w.depth < @max_hops AND w.user_id <> @target
+		AND NOT (CASE WHEN f.source_id = w.user_id THEN f.target_id ELSE f.source_id END) = ANY(w.path)
+		AND (CASE WHEN f.source_id = w.user_id THEN f.target_id ELSE f.source_id END) NOT IN (SELECT user_id FROM hidden)
+)
+SELECT depth, array_to_string(path, ',') AS path FROM walk WHERE user_id = @target LIMIT 1`
+
+// FriendPath is the shortest chain of friendships between two users, including both ends.
+type FriendPath struct {
+	Depth int
+	Path  []uint
+}
+
+// Finds the shortest friendship path from sourceID to targetID of at most maxHops hops. The second return value is
+// false when the users are not connected within that distance.
+func ShortestFriendPath(db *gorm.DB, sourceID uint, targetID uint, maxHops int) (FriendPath, bool, error) {
+	var rows []struct {
+		Depth int
+		Path  string
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET LOCAL statement_timeout = '" + separationStatementTimeout + "'").Error; err != nil {
+			return err
+		}
+		return tx.Raw(separationQuery,
+			sql.Named("source", sourceID),
+			sql.Named("target", targetID),
+			sql.Named("accepted", FriendStatusAccepted),
+			sql.Named("max_hops", maxHops),
+			sql.Named("block", RestrictionBlock),
+			sql.Named("deactivated", UserStatusDeactivated),
+		).Scan(&rows).Error
+	})
+	if err != nil || len(rows) == 0 {
+		return FriendPath{}, false, err
+	}
+
+	friendPath := FriendPath{Depth: rows[0].Depth}
+	for _, id := range strings.Split(rows[0].Path, ",") {
+		userID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return FriendPath{}, false, err
+		}
+		friendPath.Path = append(friendPath.Path, uint(userID))
+	}
+	return friendPath, true, nil
+}
@@ -0,0 +1,10 @@
+package models
+
+import "gorm.io/gorm"
+
+// Reports whether the user is a member of the group.
+func IsGroupMember(db *gorm.DB, groupID uint, userID uint) (bool, error) {
+	var members int64
+	err := db.Model(&GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&members).Error
+	return members > 0, err
+}
@@ -0,0 +1,19 @@
+package models
+
+// Statuses of a Job. Jobs waiting for a retry are pending again, with RunAt set to the time of the next attempt.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	// Jobs that ran out of attempts stay dead until an admin retries them
+	JobDead = "dead"
+)
+
+var JobStatuses = []string{JobPending, JobRunning, JobSucceeded, JobDead}
+
+// Statuses of a TaskRun
+const (
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+)
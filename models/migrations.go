@@ -0,0 +1,56 @@
+package models
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Raw SQL migrations for things GORM's AutoMigrate cannot express, such as extensions,
+// generated columns and specialised indexes. Every statement has to be idempotent because
+// the whole list is run on each startup, right after AutoMigrate.
+var migrations = []string{
+	// Full-text and fuzzy search over users and posts
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(first_name, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(middle_name, '')), 'B') ||
+		setweight(to_tsvector('simple', coalesce(last_name, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(intro, '')), 'C')
+	) STORED`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS search_name text GENERATED ALWAYS AS (
+		lower(coalesce(first_name, '') || ' ' || coalesce(middle_name, '') || ' ' || coalesce(last_name, ''))
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_users_search_vector ON users USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_users_search_name_trgm ON users USING GIN (search_name gin_trgm_ops)`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		to_tsvector('simple', coalesce(message, ''))
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING GIN (search_vector)`,
+	// Usernames are unique regardless of case, users without one are left out
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users (lower(username)) WHERE username <> ''`,
+	// Users deleted before their purge was a job get one, it waits for the end of the grace period by itself
+	`INSERT INTO jobs (queue, type, payload, status, run_at, attempts, max_attempts, created_at, updated_at)
+	SELECT 'default', 'account.purge_user', json_build_object('userID', u.id), 'pending', now(), 0, 5, now(), now()
+	FROM users u
+	WHERE u.deleted_at IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM jobs j WHERE j.type = 'account.purge_user' AND (j.payload->>'userID')::bigint = u.id)`,
+	// Users can only have one data export in the making at a time
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_pending ON data_exports (user_id) WHERE status = 'pending'`,
+	// Deactivated users are filtered out of most user queries, and there are few of them
+	`CREATE INDEX IF NOT EXISTS idx_users_deactivated ON users (id) WHERE status = 1`,
+	// Images uploaded before variants were generated by jobs get a job of their own
+	`INSERT INTO jobs (queue, type, payload, status, run_at, attempts, max_attempts, created_at, updated_at)
+	SELECT 'media', 'media.generate_variants', json_build_object('attachmentID', a.id), 'pending', now(), 0, 3, now(), now()
+	FROM attachments a
+	WHERE a.deleted_at IS NULL AND a.processed_at IS NULL AND a.content_type IN ('image/jpeg', 'image/png', 'image/gif')
+		AND NOT EXISTS (SELECT 1 FROM jobs j WHERE j.type = 'media.generate_variants' AND (j.payload->>'attachmentID')::bigint = a.id)`,
+}
+
+func runMigrations(db *gorm.DB) {
+	for _, statement := range migrations {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Println("Failed to run migration: ", err)
+		}
+	}
+}
@@ -24,6 +24,37 @@ type User struct {
 	Posts        []Post         `gorm:"foreignKey:UserID;references:ID"`
 	Groups       []Group        `gorm:"foreignKey:CreatedByID;references:ID"`
 	Token        Token          `gorm:"foreignKey:UserID;references:ID"`
+
+	// Privacy settings, see the Visibility constants. Zero means the field default applies.
+	EmailVisibility  int `validate:"omitempty,min=1,max=4"`
+	MobileVisibility int `validate:"omitempty,min=1,max=4"`
+	IntroVisibility  int `validate:"omitempty,min=1,max=4"`
+	// Who can read the user's follower and friend lists
+	ConnectionsVisibility int `validate:"omitempty,min=1,max=4"`
+	// Private accounts approve every follower and only show their posts to them
+	IsPrivate bool `gorm:"not null;default:false"`
+
+	// Denormalized counters, see counters.go. They are never read from or written by request bodies.
+	FollowerCount  int64 `gorm:"not null;default:0" json:"-"`
+	FollowingCount int64 `gorm:"not null;default:0" json:"-"`
+	FriendCount    int64 `gorm:"not null;default:0" json:"-"`
+	PostCount      int64 `gorm:"not null;default:0" json:"-"`
+
+	// Storage keys of the profile images, they are only handed out as signed URLs
+	AvatarKey string `json:"-"`
+	CoverKey  string `json:"-"`
+
+	// Handle used in @mentions, unique regardless of case. Empty until the user picks one.
+	Username string
+
+	// Admins can register global webhooks. It is only ever set in the database directly.
+	IsAdmin bool `gorm:"not null;default:false" json:"-"`
+
+	// See the UserStatus constants
+	Status int `gorm:"not null;default:0" json:"-"`
+
+	// Tokens issued before this time are rejected, see ValidSession
+	SessionsRevokedAt *time.Time `json:"-"`
 }
 
 type UserFriend struct {
@@ -48,6 +79,29 @@ type UserFollower struct {
 	Type     int
 }
 
+type UserBlock struct {
+	gorm.Model
+
+	SourceID uint `gorm:"not null;type:bigint;uniqueIndex:idx_block_source_target_type"`
+	Source   User `gorm:"foreignKey:SourceID"`
+	TargetID uint `gorm:"not null;type:bigint;index;uniqueIndex:idx_block_source_target_type"`
+	Target   User `gorm:"foreignKey:TargetID"`
+	Type     int  `gorm:"not null;uniqueIndex:idx_block_source_target_type"`
+}
+
+// UserSuggestion is a precomputed "people you may know" entry, rebuilt periodically by the suggestions job.
+type UserSuggestion struct {
+	ID            uint `gorm:"primarykey"`
+	UserID        uint `gorm:"not null;type:bigint;uniqueIndex:idx_suggestion_user_suggested"`
+	SuggestedID   uint `gorm:"not null;type:bigint;uniqueIndex:idx_suggestion_user_suggested"`
+	Suggested     User `gorm:"foreignKey:SuggestedID"`
+	MutualFriends int
+	MutualFollows int
+	SharedGroups  int
+	Score         float64 `gorm:"index"`
+	ComputedAt    time.Time
+}
+
 type Message struct {
 	gorm.Model
 
@@ -56,6 +110,8 @@ type Message struct {
 	MessageRecipientID uint `gorm:"not null" gorm:"type:bigint;index"`
 	MessageRecipient   User `gorm:"foreignKey:MessageRecipientID"`
 	Message            string
+
+	Attachments []Attachment `gorm:"foreignKey:MessageID"`
 }
 
 type Post struct {
@@ -66,6 +122,36 @@ type Post struct {
 	SenderID uint `gorm:"type:bigint;index"`
 	Sender   User `gorm:"foreignKey:SenderID"`
 	Message  string
+
+	// Denormalized number of comments, replies included
+	CommentCount int64 `gorm:"not null;default:0" json:"-"`
+
+	Attachments []Attachment `gorm:"foreignKey:PostID"`
+}
+
+type PostReaction struct {
+	gorm.Model
+
+	PostID uint   `gorm:"not null;type:bigint;uniqueIndex:idx_reaction_post_user"`
+	Post   Post   `gorm:"foreignKey:PostID"`
+	UserID uint   `gorm:"not null;type:bigint;index;uniqueIndex:idx_reaction_post_user"`
+	User   User   `gorm:"foreignKey:UserID"`
+	Kind   string `gorm:"not null;index"`
+}
+
+// PostComment is a comment on a post. Replies point to a top-level comment through ParentID, there is only one
+// level of replies.
+type PostComment struct {
+	gorm.Model
+
+	PostID     uint         `gorm:"not null;type:bigint;index"`
+	Post       Post         `gorm:"foreignKey:PostID"`
+	UserID     uint         `gorm:"not null;type:bigint;index"`
+	User       User         `gorm:"foreignKey:UserID"`
+	ParentID   *uint        `gorm:"type:bigint;index"`
+	Parent     *PostComment `gorm:"foreignKey:ParentID"`
+	Message    string       `gorm:"not null"`
+	ReplyCount int64        `gorm:"not null;default:0"`
 }
 
 type Group struct {
@@ -112,6 +198,227 @@ type GroupMessage struct {
 	UserID  uint
 	User    User `gorm:"foreignKey:UserID"`
 	Message string
+
+	Attachments []Attachment `gorm:"foreignKey:GroupMessageID"`
+}
+
+// Attachment is an uploaded file. It only belongs to its owner until it is attached to a post, a message or a group
+// message, from then on whoever can see that can see the attachment as well.
+type Attachment struct {
+	gorm.Model
+
+	OwnerID     uint   `gorm:"not null;type:bigint;index"`
+	Owner       User   `gorm:"foreignKey:OwnerID"`
+	StorageKey  string `gorm:"not null;unique"`
+	ContentType string `gorm:"not null"`
+	Size        int64
+	// Dimensions of images, zero for other files and image formats that cannot be decoded
+	Width  int
+	Height int
+
+	PostID         *uint `gorm:"type:bigint;index"`
+	MessageID      *uint `gorm:"type:bigint;index"`
+	GroupMessageID *uint `gorm:"type:bigint;index"`
+
+	// Resized versions of images, generated in the background by a job queued on upload
+	Variants    []AttachmentVariant `gorm:"foreignKey:AttachmentID"`
+	ProcessedAt *time.Time          `gorm:"index"`
+}
+
+type AttachmentVariant struct {
+	ID           uint   `gorm:"primarykey"`
+	AttachmentID uint   `gorm:"not null;type:bigint;uniqueIndex:idx_variant_attachment_name"`
+	Name         string `gorm:"not null;uniqueIndex:idx_variant_attachment_name"`
+	StorageKey   string `gorm:"not null;unique"`
+	ContentType  string `gorm:"not null"`
+	Size         int64
+	Width        int
+	Height       int
+	CreatedAt    time.Time
+}
+
+type Hashtag struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"not null;uniqueIndex"`
+	CreatedAt time.Time
+}
+
+// The tables below link posts and group messages to the hashtags and users they mention. They are rebuilt from the
+// message text whenever it is created or edited.
+type PostHashtag struct {
+	PostID    uint      `gorm:"primaryKey;type:bigint"`
+	HashtagID uint      `gorm:"primaryKey;type:bigint;index"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+type GroupMessageHashtag struct {
+	GroupMessageID uint `gorm:"primaryKey;type:bigint"`
+	HashtagID      uint `gorm:"primaryKey;type:bigint;index"`
+	CreatedAt      time.Time
+}
+
+type PostMention struct {
+	PostID    uint `gorm:"primaryKey;type:bigint"`
+	UserID    uint `gorm:"primaryKey;type:bigint;index"`
+	CreatedAt time.Time
+}
+
+type GroupMessageMention struct {
+	GroupMessageID uint `gorm:"primaryKey;type:bigint"`
+	UserID         uint `gorm:"primaryKey;type:bigint;index"`
+	CreatedAt      time.Time
+}
+
+// Notification tells UserID that ActorID did something, described by Type, to the subject identified by SubjectType
+// and SubjectID.
+type Notification struct {
+	ID          uint   `gorm:"primarykey"`
+	UserID      uint   `gorm:"not null;type:bigint;index:idx_notification_user_created"`
+	ActorID     uint   `gorm:"not null;type:bigint"`
+	Actor       User   `gorm:"foreignKey:ActorID"`
+	Type        string `gorm:"not null"`
+	SubjectType string
+	SubjectID   uint `gorm:"type:bigint"`
+	ReadAt      *time.Time
+	CreatedAt   time.Time `gorm:"index:idx_notification_user_created"`
+}
+
+// NotificationSubscription is a place outside the app where a user wants their notifications delivered, a browser
+// registered for Web Push or a webhook URL.
+type NotificationSubscription struct {
+	gorm.Model
+
+	UserID   uint   `gorm:"not null;type:bigint;index"`
+	Channel  string `gorm:"not null"`
+	Endpoint string `gorm:"not null"`
+	// Keys of the browser's push subscription, see RFC 8291
+	P256dh string `json:"-"`
+	Auth   string `json:"-"`
+	// Key the payloads of webhooks are signed with
+	Secret string `json:"-"`
+	// Subscriptions the channel reported as gone are disabled instead of retried
+	DisabledAt *time.Time
+}
+
+// NotificationPreference turns delivery of one notification type over one channel on or off. Without a row, delivery
+// is on.
+type NotificationPreference struct {
+	UserID  uint   `gorm:"primaryKey;type:bigint"`
+	Type    string `gorm:"primaryKey"`
+	Channel string `gorm:"primaryKey"`
+	Enabled bool   `gorm:"not null"`
+}
+
+// NotificationDelivery tracks sending one notification to one subscription, including the retries after failures.
+type NotificationDelivery struct {
+	ID             uint      `gorm:"primarykey"`
+	NotificationID uint      `gorm:"not null;type:bigint;index"`
+	SubscriptionID uint      `gorm:"not null;type:bigint;index"`
+	Status         string    `gorm:"not null;index:idx_delivery_status_next_attempt"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"index:idx_delivery_status_next_attempt"`
+	LockedUntil    *time.Time
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+// OutboxEvent is a domain event written in the same transaction as the change it describes. The dispatcher hands it
+// to the subscribers of its Name after the transaction committed.
+type OutboxEvent struct {
+	ID            uint      `gorm:"primarykey"`
+	Name          string    `gorm:"not null"`
+	Payload       string    `gorm:"type:jsonb;not null"`
+	Status        string    `gorm:"not null;index:idx_outbox_status_next_attempt"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index:idx_outbox_status_next_attempt"`
+	LockedUntil   *time.Time
+	LastError     string
+	ProcessedAt   *time.Time
+	CreatedAt     time.Time
+}
+
+// Webhook is an endpoint outside the app that domain events are POSTed to. Regular webhooks receive the events
+// concerning their owner, global webhooks, which only admins can register, receive the events of every user.
+type Webhook struct {
+	gorm.Model
+
+	OwnerID uint   `gorm:"not null;type:bigint;index"`
+	URL     string `gorm:"not null"`
+	// Comma separated names of the events the webhook receives
+	Events   string `gorm:"not null"`
+	IsGlobal bool   `gorm:"not null;default:false"`
+	// Key the payloads are signed with
+	Secret string `json:"-"`
+	// Failed attempts since the last successful one. Webhooks failing too often in a row are disabled.
+	ConsecutiveFailures int `gorm:"not null;default:0"`
+	DisabledAt          *time.Time
+}
+
+// WebhookDelivery tracks sending one event to one webhook, including the response of the last attempt.
+type WebhookDelivery struct {
+	ID        uint `gorm:"primarykey"`
+	WebhookID uint `gorm:"not null;type:bigint;uniqueIndex:idx_webhook_delivery_event"`
+	// ID of the outbox event, which stays the same when the event is delivered again
+	EventID        uint      `gorm:"not null;type:bigint;uniqueIndex:idx_webhook_delivery_event"`
+	EventName      string    `gorm:"not null"`
+	Payload        string    `gorm:"type:jsonb;not null"`
+	Status         string    `gorm:"not null;index:idx_webhook_delivery_status_next_attempt"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"index:idx_webhook_delivery_status_next_attempt"`
+	LockedUntil    *time.Time
+	ResponseStatus int
+	ResponseBody   string
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Job is a unit of background work, run by the worker pool of its queue once RunAt has passed.
+type Job struct {
+	ID          uint      `gorm:"primarykey"`
+	Queue       string    `gorm:"not null;index:idx_jobs_queue_status_run_at"`
+	Type        string    `gorm:"not null;index"`
+	Payload     string    `gorm:"type:jsonb;not null"`
+	Status      string    `gorm:"not null;index:idx_jobs_queue_status_run_at"`
+	RunAt       time.Time `gorm:"not null;index:idx_jobs_queue_status_run_at"`
+	Attempts    int       `gorm:"not null;default:0"`
+	MaxAttempts int       `gorm:"not null"`
+	// Running jobs whose lock expired are assumed to have crashed their worker and are picked up again
+	LockedUntil *time.Time
+	LastError   string
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TaskRun records one run of a scheduled task. There is at most one run per task and scheduled time, however many
+// instances run the scheduler.
+type TaskRun struct {
+	ID          uint      `gorm:"primarykey"`
+	Task        string    `gorm:"not null;uniqueIndex:idx_task_run_scheduled"`
+	ScheduledAt time.Time `gorm:"not null;uniqueIndex:idx_task_run_scheduled"`
+	// Host the run happened on
+	Instance   string
+	Status     string `gorm:"not null"`
+	Error      string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// DataExport is an archive of everything stored about a user, built in the background on their request. Ready archives
+// can be downloaded until ExpiresAt, after which their file is deleted.
+type DataExport struct {
+	ID          uint   `gorm:"primarykey"`
+	UserID      uint   `gorm:"not null;type:bigint;index"`
+	Status      string `gorm:"not null"`
+	StorageKey  string
+	Size        int64
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
 }
 
 type Token struct {
@@ -124,6 +431,6 @@ type Token struct {
 
 func AutoMigrate(db *gorm.DB) {
 	// AutoMigrate will create the necessary tables in the database
-	db.AutoMigrate(&User{}, &Message{}, &UserFriend{}, &UserFollower{}, &Message{}, &Post{}, &Group{}, &GroupMeta{}, &GroupMember{}, &GroupMessage{}, &Token{})
-
+	db.AutoMigrate(&User{}, &Message{}, &UserFriend{}, &UserFollower{}, &Message{}, &Post{}, &Group{}, &GroupMeta{}, &GroupMember{}, &GroupMessage{}, &Token{}, &UserBlock{}, &UserSuggestion{}, &PostReaction{}, &PostComment{}, &Attachment{}, &AttachmentVariant{}, &Hashtag{}, &PostHashtag{}, &GroupMessageHashtag{}, &PostMention{}, &GroupMessageMention{}, &Notification{}, &NotificationSubscription{}, &NotificationPreference{}, &NotificationDelivery{}, &OutboxEvent{}, &Webhook{}, &WebhookDelivery{}, &Job{}, &TaskRun{}, &DataExport{})
+	runMigrations(db)
 }
@@ -0,0 +1,68 @@
+package models
+
+// Notification types
+const (
+	NotificationFollow         = "follow"
+	NotificationFollowRequest  = "follow_request"
+	NotificationFollowAccepted = "follow_accepted"
+	NotificationFriendRequest  = "friend_request"
+	NotificationFriendAccepted = "friend_accepted"
+	NotificationMessage        = "message"
+	NotificationComment        = "comment"
+	NotificationReply          = "reply"
+	NotificationGroupJoin      = "group_join"
+	NotificationMention        = "mention"
+	// Sent to users whose requested data export can be downloaded
+	NotificationDataExport = "data_export"
+)
+
+// Notification types users can receive, in the order they are listed in preferences
+var NotificationTypes = []string{
+	NotificationFollow, NotificationFollowRequest, NotificationFollowAccepted, NotificationFriendRequest,
+	NotificationFriendAccepted, NotificationMessage, NotificationComment, NotificationReply, NotificationGroupJoin,
+	NotificationMention, NotificationDataExport,
+}
+
+// Channels notifications are delivered over besides the app itself
+const (
+	ChannelWebPush = "webpush"
+	ChannelWebhook = "webhook"
+)
+
+var NotificationChannels = []string{ChannelWebPush, ChannelWebhook}
+
+// Statuses of a NotificationDelivery or a WebhookDelivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+func IsNotificationType(notificationType string) bool {
+	for _, known := range NotificationTypes {
+		if notificationType == known {
+			return true
+		}
+	}
+	return false
+}
+
+func IsNotificationChannel(channel string) bool {
+	for _, known := range NotificationChannels {
+		if channel == known {
+			return true
+		}
+	}
+	return false
+}
+
+// What a notification is about, see Notification.SubjectType. Notifications about the recipient's relationships and
+// messages use the recipient as their subject, so that all of them can be grouped together.
+const (
+	SubjectUser         = "user"
+	SubjectPost         = "post"
+	SubjectComment      = "comment"
+	SubjectGroup        = "group"
+	SubjectGroupMessage = "group_message"
+	SubjectDataExport   = "data_export"
+)
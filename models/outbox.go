@@ -0,0 +1,8 @@
+package models
+
+// Statuses of an OutboxEvent
+const (
+	OutboxPending   = "pending"
+	OutboxProcessed = "processed"
+	OutboxFailed    = "failed"
+)
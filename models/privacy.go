@@ -0,0 +1,36 @@
+package models
+
+// Visibility levels for the privacy settings on User. Zero is deliberately not a valid level, so that users
+// created before the settings existed fall back to the per-field defaults below.
+const (
+	VisibilityPublic = iota + 1
+	VisibilityFollowers
+	VisibilityFriends
+	VisibilityOnlyMe
+)
+
+const (
+	DefaultEmailVisibility       = VisibilityOnlyMe
+	DefaultMobileVisibility      = VisibilityOnlyMe
+	DefaultIntroVisibility       = VisibilityPublic
+	DefaultConnectionsVisibility = VisibilityPublic
+)
+
+// Returns the visibility level to use for a stored setting, falling back to the default when it is unset.
+func EffectiveVisibility(visibility int, fallback int) int {
+	if visibility < VisibilityPublic || visibility > VisibilityOnlyMe {
+		return fallback
+	}
+	return visibility
+}
+
+// Returns the privacy settings of the user with defaults applied.
+func (u User) PrivacySettings() PrivacySettings {
+	return PrivacySettings{
+		EmailVisibility:       EffectiveVisibility(u.EmailVisibility, DefaultEmailVisibility),
+		MobileVisibility:      EffectiveVisibility(u.MobileVisibility, DefaultMobileVisibility),
+		IntroVisibility:       EffectiveVisibility(u.IntroVisibility, DefaultIntroVisibility),
+		ConnectionsVisibility: EffectiveVisibility(u.ConnectionsVisibility, DefaultConnectionsVisibility),
+		IsPrivate:             u.IsPrivate,
+	}
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// PurgeUser permanently deletes a user, soft-deleted or not, together with everything they created or that only
+// exists because of them. Groups they own go to their longest standing member, groups without other members are
+// deleted. The storage keys of the deleted files are returned, so the caller can remove them once the transaction
+// committed. Counters of other users and posts are left for the counter reconciler to repair.
+func PurgeUser(tx *gorm.DB, userID uint) ([]string, error) {
+	var user User
+	if err := tx.Unscoped().First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	// Hand every group with other members over to whoever joined it first
+	err := tx.Exec(`
+UPDATE groups SET created_by_id = successors.user_id, updated_by_id = successors.user_id
+FROM (
+	SELECT DISTINCT ON (group_id) group_id, user_id FROM group_members
+	WHERE user_id <> ? AND deleted_at IS NULL
+	ORDER BY group_id, created_at, id
+) AS successors
+WHERE groups.id = successors.group_id AND groups.created_by_id = ?`, userID, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&Group{}).Unscoped().Where("updated_by_id = ?", userID).Update("updated_by_id", gorm.Expr("created_by_id")).Error; err != nil {
+		return nil, err
+	}
+
+	// Subqueries selecting what goes away with the user. They are built anew for every use.
+	groups := func() *gorm.DB {
+		return tx.Model(&Group{}).Unscoped().Select("id").Where("created_by_id = ?", userID)
+	}
+	groupMessages := func() *gorm.DB {
+		return tx.Model(&GroupMessage{}).Unscoped().Select("id").Where("user_id = ? OR group_id IN (?)", userID, groups())
+	}
+	posts := func() *gorm.DB {
+		return tx.Model(&Post{}).Unscoped().Select("id").Where("user_id = ? OR sender_id = ?", userID, userID)
+	}
+	comments := func() *gorm.DB {
+		return tx.Model(&PostComment{}).Unscoped().Select("id").Where("user_id = ? OR post_id IN (?)", userID, posts())
+	}
+	messages := func() *gorm.DB {
+		return tx.Model(&Message{}).Unscoped().Select("id").Where("message_sender_id = ? OR message_recipient_id = ?", userID, userID)
+	}
+	attachments := func() *gorm.DB {
+		return tx.Model(&Attachment{}).Unscoped().Select("id").
+			Where("owner_id = ? OR post_id IN (?) OR message_id IN (?) OR group_message_id IN (?)", userID, posts(), messages(), groupMessages())
+	}
+	notifications := func() *gorm.DB {
+		return tx.Model(&Notification{}).Select("id").Where("user_id = ? OR actor_id = ?", userID, userID)
+	}
+
+	var storageKeys []string
+	if err := tx.Model(&Attachment{}).Unscoped().Where("id IN (?)", attachments()).Pluck("storage_key", &storageKeys).Error; err != nil {
+		return nil, err
+	}
+	var variantKeys []string
+	if err := tx.Model(&AttachmentVariant{}).Where("attachment_id IN (?)", attachments()).Pluck("storage_key", &variantKeys).Error; err != nil {
+		return nil, err
+	}
+	storageKeys = append(storageKeys, variantKeys...)
+	var exportKeys []string
+	if err := tx.Model(&DataExport{}).Where("user_id = ? AND storage_key <> ''", userID).Pluck("storage_key", &exportKeys).Error; err != nil {
+		return nil, err
+	}
+	storageKeys = append(storageKeys, exportKeys...)
+	for _, key := range []string{user.AvatarKey, user.CoverKey} {
+		if key != "" {
+			storageKeys = append(storageKeys, key)
+		}
+	}
+
+	// Children go before the rows they reference
+	deletes := []struct {
+		model interface{}
+		query string
+		args  []interface{}
+	}{
+		{&AttachmentVariant{}, "attachment_id IN (?)", []interface{}{attachments()}},
+		{&Attachment{}, "id IN (?)", []interface{}{attachments()}},
+		{&PostHashtag{}, "post_id IN (?)", []interface{}{posts()}},
+		{&PostMention{}, "post_id IN (?) OR user_id = ?", []interface{}{posts(), userID}},
+		{&GroupMessageHashtag{}, "group_message_id IN (?)", []interface{}{groupMessages()}},
+		{&GroupMessageMention{}, "group_message_id IN (?) OR user_id = ?", []interface{}{groupMessages(), userID}},
+		{&PostReaction{}, "user_id = ? OR post_id IN (?)", []interface{}{userID, posts()}},
+		// Replies first, they reference the comments they answer
+		{&PostComment{}, "parent_id IN (?)", []interface{}{comments()}},
+		{&PostComment{}, "id IN (?)", []interface{}{comments()}},
+		{&Post{}, "id IN (?)", []interface{}{posts()}},
+		{&GroupMessage{}, "id IN (?)", []interface{}{groupMessages()}},
+		{&GroupMember{}, "user_id = ? OR group_id IN (?)", []interface{}{userID, groups()}},
+		{&GroupMeta{}, "group_id IN (?)", []interface{}{groups()}},
+		{&Group{}, "id IN (?)", []interface{}{groups()}},
+		{&Message{}, "id IN (?)", []interface{}{messages()}},
+		{&UserFriend{}, "source_id = ? OR target_id = ?", []interface{}{userID, userID}},
+		{&UserFollower{}, "source_id = ? OR target_id = ?", []interface{}{userID, userID}},
+		{&UserBlock{}, "source_id = ? OR target_id = ?", []interface{}{userID, userID}},
+		{&UserSuggestion{}, "user_id = ? OR suggested_id = ?", []interface{}{userID, userID}},
+		{&NotificationDelivery{}, "notification_id IN (?) OR subscription_id IN (?)", []interface{}{notifications(),
+			tx.Model(&NotificationSubscription{}).Unscoped().Select("id").Where("user_id = ?", userID)}},
+		{&Notification{}, "id IN (?)", []interface{}{notifications()}},
+		{&NotificationSubscription{}, "user_id = ?", []interface{}{userID}},
+		{&NotificationPreference{}, "user_id = ?", []interface{}{userID}},
+		{&WebhookDelivery{}, "webhook_id IN (?)", []interface{}{tx.Model(&Webhook{}).Unscoped().Select("id").Where("owner_id = ?", userID)}},
+		{&Webhook{}, "owner_id = ?", []interface{}{userID}},
+		{&DataExport{}, "user_id = ?", []interface{}{userID}},
+		{&Token{}, "user_id = ?", []interface{}{userID}},
+	}
+	for _, d := range deletes {
+		if err := tx.Unscoped().Where(d.query, d.args...).Delete(d.model).Error; err != nil {
+			return nil, err
+		}
+	}
+	return storageKeys, tx.Unscoped().Delete(&user).Error
+}
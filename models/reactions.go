@@ -0,0 +1,38 @@
+package models
+
+import (
+	"os"
+	"strings"
+)
+
+const ReactionLike = "like"
+
+// Reaction kinds used when REACTION_KINDS is not set
+var defaultReactionKinds = []string{ReactionLike, "love", "haha", "wow", "sad", "angry"}
+
+// Returns the reaction kinds users may react with. They can be configured with a comma separated REACTION_KINDS
+// environment variable, "like" is always allowed.
+func AllowedReactionKinds() []string {
+	configured := os.Getenv("REACTION_KINDS")
+	if configured == "" {
+		return defaultReactionKinds
+	}
+
+	kinds := []string{ReactionLike}
+	for _, kind := range strings.Split(configured, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind != "" && kind != ReactionLike {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+func IsAllowedReactionKind(kind string) bool {
+	for _, allowed := range AllowedReactionKinds() {
+		if kind == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package models
+
+// Values of UserFriend.Status. Accepted is zero so that rows created before friend requests existed stay friendships.
+const (
+	FriendStatusAccepted = iota
+	FriendStatusPending
+)
+
+// Values of UserFollower.Type. Follows of private accounts stay pending until the target approves them, and only
+// active follows count as followers anywhere.
+const (
+	FollowTypeActive = iota
+	FollowTypePending
+)
+
+// Values of UserBlock.Type. A block cuts every connection between two users, a mute only hides content from the
+// muter's timeline.
+const (
+	RestrictionBlock = iota + 1
+	RestrictionMute
+)
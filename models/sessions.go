@@ -0,0 +1,36 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Column of User holding when the user's sessions were last revoked, it is never written from request bodies
+const SessionsRevokedAtColumn = "sessions_revoked_at"
+
+// Reports whether a token of the user issued at issuedAt may still be used, which it can while the user is not
+// deleted and their sessions were not revoked after the token was issued.
+func ValidSession(db *gorm.DB, userID uint, issuedAt time.Time) (bool, error) {
+	var user User
+	err := db.Select("id", SessionsRevokedAtColumn).Take(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.SessionsRevokedAt == nil || !issuedAt.Before(*user.SessionsRevokedAt), nil
+}
+
+// Signs the user out everywhere. Tokens carry the second they were issued in, so the revocation time is truncated
+// to seconds to keep tokens issued right after it valid.
+func RevokeSessions(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&User{}).Unscoped().Where("id = ?", userID).
+		UpdateColumn(SessionsRevokedAtColumn, time.Now().Truncate(time.Second)).Error
+	if err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("user_id = ?", userID).Delete(&Token{}).Error
+}
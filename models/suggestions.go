@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// How many suggestions are kept for every user
+	MaxSuggestionsPerUser = 50
+
+	mutualFriendWeight = 3
+	sharedGroupWeight  = 2
+	mutualFollowWeight = 1
+)
+
+// Candidates are collected from three graphs: friends of friends, users followed by the users one follows, and
+// members of the same groups. Anyone already connected to the user, in either direction and in any state, or blocked
+// either way is excluded, then only the best MaxSuggestionsPerUser candidates of every user are kept.
+const computeSuggestionsQuery = `
+WITH friends AS (
+	SELECT source_id AS user_id, target_id AS friend_id FROM user_friends WHERE status = @accepted AND deleted_at IS NULL
+	UNION
+	SELECT target_id AS user_id, source_id AS friend_id FROM user_friends WHERE status = @accepted AND deleted_at IS NULL
+), follows AS (
+	SELECT source_id AS user_id, target_id AS followed_id FROM user_followers WHERE type = @active AND deleted_at IS NULL
+), memberships AS (
+	SELECT DISTINCT user_id, group_id FROM group_members WHERE deleted_at IS NULL
+), candidates AS (
+	SELECT f1.user_id, f2.friend_id AS suggested_id, count(*) AS mutual_friends, 0 AS mutual_follows, 0 AS shared_groups
+	FROM friends f1 JOIN friends f2 ON f2.user_id = f1.friend_id
+	WHERE f2.friend_id <> f1.user_id
+	GROUP BY f1.user_id, f2.friend_id
+	UNION ALL
+	SELECT a.user_id, b.followed_id, 0, count(*), 0
+	FROM follows a JOIN follows b ON b.user_id = a.followed_id
+	WHERE b.followed_id <> a.user_id
+	GROUP BY a.user_id, b.followed_id
+	UNION ALL
+	SELECT m1.user_id, m2.user_id, 0, 0, count(*)
+	FROM memberships m1 JOIN memberships m2 ON m2.group_id = m1.group_id AND m2.user_id <> m1.user_id
+	GROUP BY m1.user_id, m2.user_id
+), scored AS (
+	SELECT user_id, suggested_id,
+		sum(mutual_friends) AS mutual_friends,
+		sum(mutual_follows) AS mutual_follows,
+		sum(shared_groups) AS shared_groups,
+		sum(mutual_friends) * @friend_weight + sum(shared_groups) * @group_weight + sum(mutual_follows) * @follow_weight AS score
+	FROM candidates
+	GROUP BY user_id, suggested_id
+), ranked AS (
+	SELECT s.*, row_number() OVER (PARTITION BY s.user_id ORDER BY s.score DESC, s.suggested_id) AS position
+	FROM scored s
+	JOIN users u ON u.id = s.user_id AND u.deleted_at IS NULL
+	JOIN users su ON su.id = s.suggested_id AND su.deleted_at IS NULL
+	WHERE NOT EXISTS (
+		SELECT 1 FROM user_friends uf WHERE uf.deleted_at IS NULL AND
+			((uf.source_id = s.user_id AND uf.target_id = s.suggested_id) OR (uf.source_id = s.suggested_id AND uf.target_id = s.user_id))
+	) AND NOT EXISTS (
+		SELECT 1 FROM user_followers uf WHERE uf.deleted_at IS NULL AND uf.source_id = s.user_id AND uf.target_id = s.suggested_id
+	) AND NOT EXISTS (
+		SELECT 1 FROM user_blocks ub WHERE ub.deleted_at IS NULL AND ub.type = @block AND
+			((ub.source_id = s.user_id AND ub.target_id = s.suggested_id) OR (ub.source_id = s.suggested_id AND ub.target_id = s.user_id))
+	)
+)
+INSERT INTO user_suggestions (user_id, suggested_id, mutual_friends, mutual_follows, shared_groups, score, computed_at)
+SELECT user_id, suggested_id, mutual_friends, mutual_follows, shared_groups, score, now()
+FROM ranked
+WHERE position <= @limit`
+
+// Rebuilds the whole user_suggestions table in one transaction, so readers never see a half computed state.
+// Returns the number of suggestions stored.
+func ComputeSuggestions(db *gorm.DB) (int64, error) {
+	var stored int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM user_suggestions").Error; err != nil {
+			return err
+		}
+		result := tx.Exec(computeSuggestionsQuery,
+			sql.Named("accepted", FriendStatusAccepted),
+			sql.Named("active", FollowTypeActive),
+			sql.Named("block", RestrictionBlock),
+			sql.Named("friend_weight", mutualFriendWeight),
+			sql.Named("group_weight", sharedGroupWeight),
+			sql.Named("follow_weight", mutualFollowWeight),
+			sql.Named("limit", MaxSuggestionsPerUser),
+		)
+		stored = result.RowsAffected
+		return result.Error
+	})
+	return stored, err
+}
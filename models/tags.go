@@ -0,0 +1,140 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Hashtags start after whitespace or punctuation and need at least one letter, so "#1" or "a#b" are not tags.
+// Mentions follow the username rules, see UsernamePattern.
+var (
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#])#([\p{L}\p{N}_]{1,50})`)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@.])@([A-Za-z0-9_]{3,30})`)
+	hasLetter      = regexp.MustCompile(`\p{L}`)
+)
+
+// Usernames are 3 to 30 letters, digits or underscores
+var UsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// Returns the distinct hashtags in message, lowercased and without the leading #.
+func ParseHashtags(message string) []string {
+	var hashtags []string
+	seen := map[string]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(message, -1) {
+		hashtag := strings.ToLower(match[1])
+		if !hasLetter.MatchString(hashtag) || seen[hashtag] {
+			continue
+		}
+		seen[hashtag] = true
+		hashtags = append(hashtags, hashtag)
+	}
+	return hashtags
+}
+
+// Returns the distinct usernames mentioned in message, lowercased and without the leading @.
+func ParseMentions(message string) []string {
+	var usernames []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(message, -1) {
+		username := strings.ToLower(match[1])
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// Updates the hashtags and mentions of a post to match its message. The users that are mentioned now but were not
+// before are returned, so that editing a post only notifies the newly mentioned.
+func SyncPostTags(tx *gorm.DB, post Post) ([]uint, error) {
+	if err := syncHashtags(tx, "post_hashtags", "post_id", post.ID, post.Message); err != nil {
+		return nil, err
+	}
+	return syncMentions(tx, "post_mentions", "post_id", post.ID, post.SenderID, post.Message)
+}
+
+// Updates the hashtags and mentions of a group message to match its text, like SyncPostTags.
+func SyncGroupMessageTags(tx *gorm.DB, message GroupMessage) ([]uint, error) {
+	if err := syncHashtags(tx, "group_message_hashtags", "group_message_id", message.ID, message.Message); err != nil {
+		return nil, err
+	}
+	return syncMentions(tx, "group_message_mentions", "group_message_id", message.ID, message.UserID, message.Message)
+}
+
+func syncHashtags(tx *gorm.DB, table string, column string, id uint, message string) error {
+	names := ParseHashtags(message)
+	if len(names) == 0 {
+		return tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ?", id).Error
+	}
+
+	hashtags := make([]Hashtag, 0, len(names))
+	for _, name := range names {
+		hashtags = append(hashtags, Hashtag{Name: name})
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&hashtags).Error; err != nil {
+		return err
+	}
+	var hashtagIDs []uint
+	if err := tx.Model(&Hashtag{}).Where("name IN ?", names).Pluck("id", &hashtagIDs).Error; err != nil {
+		return err
+	}
+
+	err := tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ? AND hashtag_id NOT IN ?", id, hashtagIDs).Error
+	if err != nil {
+		return err
+	}
+	return insertLinks(tx, table, column, id, "hashtag_id", hashtagIDs)
+}
+
+func syncMentions(tx *gorm.DB, table string, column string, id uint, authorID uint, message string) ([]uint, error) {
+	var mentionedIDs []uint
+	if usernames := ParseMentions(message); len(usernames) > 0 {
+		err := tx.Model(&User{}).Where("lower(username) IN ? AND id <> ?", usernames, authorID).Pluck("id", &mentionedIDs).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(mentionedIDs) == 0 {
+		return nil, tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ?", id).Error
+	}
+
+	var existingIDs []uint
+	if err := tx.Table(table).Where(column+" = ?", id).Pluck("user_id", &existingIDs).Error; err != nil {
+		return nil, err
+	}
+	existing := map[uint]bool{}
+	for _, userID := range existingIDs {
+		existing[userID] = true
+	}
+	var addedIDs []uint
+	for _, userID := range mentionedIDs {
+		if !existing[userID] {
+			addedIDs = append(addedIDs, userID)
+		}
+	}
+
+	err := tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ? AND user_id NOT IN ?", id, mentionedIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return addedIDs, insertLinks(tx, table, column, id, "user_id", addedIDs)
+}
+
+// Inserts the rows linking id to every one of targetIDs into a link table, skipping those that already exist.
+func insertLinks(tx *gorm.DB, table string, column string, id uint, targetColumn string, targetIDs []uint) error {
+	if len(targetIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	rows := make([]map[string]interface{}, 0, len(targetIDs))
+	for _, targetID := range targetIDs {
+		rows = append(rows, map[string]interface{}{column: id, targetColumn: targetID, "created_at": now})
+	}
+	return tx.Table(table).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
@@ -11,6 +14,38 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// CreateUserRequest holds what a new user may set about themselves. Its fields have the names of the User fields
+// they are copied to, so existing clients keep working.
+type CreateUserRequest struct {
+	FirstName    string `validate:"required,max=20"`
+	MiddleName   string
+	LastName     string `validate:"required,max=20"`
+	Mobile       string `validate:"required,min=5,max=20"`
+	Email        string `validate:"required,min=5,max=45"`
+	PasswordHash string `validate:"required,min=5,max=85"`
+	Intro        string
+
+	EmailVisibility       int `validate:"omitempty,min=1,max=4"`
+	MobileVisibility      int `validate:"omitempty,min=1,max=4"`
+	IntroVisibility       int `validate:"omitempty,min=1,max=4"`
+	ConnectionsVisibility int `validate:"omitempty,min=1,max=4"`
+	IsPrivate             bool
+}
+
+// UpdateUserRequest holds the profile fields a user may change with a profile update. Everything else about the
+// account has an endpoint of its own or is never set by users.
+type UpdateUserRequest struct {
+	FirstName  string `validate:"required,max=20"`
+	MiddleName string
+	LastName   string `validate:"required,max=20"`
+	Mobile     string `validate:"required,min=5,max=20"`
+	Email      string `validate:"required,min=5,max=45"`
+	Intro      string
+}
+
+// Columns of User written by a profile update, the ones of UpdateUserRequest
+var UpdateUserColumns = []string{"first_name", "middle_name", "last_name", "mobile", "email", "intro"}
+
 type Loginresponse struct {
 	Token  string     `json:"token"`
 	Claims jwt.Claims `json:"claims"`
@@ -21,9 +56,315 @@ type UserProfile struct {
 	FirstName  string `gorm:"not null" validate:"required,max=20"`
 	MiddleName string
 	LastName   string `gorm:"not null" validate:"required,max=20"`
-	Mobile     string `gorm:"unique;not null" validate:"required,min=5,max=20"`
-	Email      string `gorm:"unique;not null" validate:"required,min=5,max=45"`
-	Intro      string
+	Mobile     string `gorm:"unique;not null" validate:"required,min=5,max=20" json:",omitempty"`
+	Email      string `gorm:"unique;not null" validate:"required,min=5,max=45" json:",omitempty"`
+	Intro      string `json:",omitempty"`
+	IsPrivate  bool
+	Username   string `json:",omitempty"`
+	AvatarURL  string `json:",omitempty"`
+	CoverURL   string `json:",omitempty"`
+
+	FollowerCount  int64
+	FollowingCount int64
+	FriendCount    int64
+	PostCount      int64
+}
+
+// Relationship describes how the requesting user is connected to another user.
+type Relationship struct {
+	UserID     uint
+	Following  bool
+	FollowedBy bool
+	Friends    bool
+
+	FollowRequestSent     bool
+	FollowRequestReceived bool
+
+	FriendRequestSent     bool
+	FriendRequestReceived bool
+
+	Blocking bool
+	Muting   bool
+}
+
+type SuggestionResponse struct {
+	Profile       UserProfile
+	MutualFriends int
+	MutualFollows int
+	SharedGroups  int
+	Score         float64
+}
+
+// Posts and messages need either text or at least one attachment, uploaded beforehand through /media
+type PostRequest struct {
+	Message       string `validate:"required_without=AttachmentIDs,max=2000"`
+	AttachmentIDs []uint `validate:"max=10"`
+}
+
+// Edits the text of a post or a group message
+type MessageUpdateRequest struct {
+	Message string `validate:"required,max=2000"`
+}
+
+type MessageRequest struct {
+	Message       string `validate:"required_without=AttachmentIDs,max=2000"`
+	AttachmentIDs []uint `validate:"max=10"`
+}
+
+type MessageResponse struct {
+	ID                 uint
+	MessageSenderID    uint
+	MessageRecipientID uint
+	Message            string
+	CreatedAt          time.Time
+	Attachments        []AttachmentResponse
+}
+
+// A group of notifications of the same type about the same subject, listed as one entry
+type NotificationGroupResponse struct {
+	// The newest notification of the group, marking it read marks the whole group read
+	ID          uint
+	Type        string
+	SubjectType string
+	SubjectID   uint
+	Summary     string
+	// The most recent actors, and the number of distinct actors in the whole group
+	Actors     []UserProfile
+	ActorCount int64
+	Count      int64
+	Unread     bool
+	LatestAt   time.Time
+}
+
+// Browsers send their PushSubscription as is, webhooks only need the endpoint
+type SubscriptionRequest struct {
+	Channel  string `validate:"required,oneof=webpush webhook"`
+	Endpoint string `validate:"required,url,max=2000"`
+	Keys     struct {
+		P256dh string `validate:"max=200"`
+		Auth   string `validate:"max=100"`
+	}
+}
+
+type SubscriptionResponse struct {
+	ID       uint
+	Channel  string
+	Endpoint string
+	Disabled bool
+	// The key webhook payloads are signed with, only returned when the subscription is created
+	Secret    string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+type PreferenceRequest struct {
+	Preferences []NotificationPreferenceItem `validate:"required,dive"`
+}
+
+type NotificationPreferenceItem struct {
+	Type    string `validate:"required"`
+	Channel string `validate:"required,oneof=webpush webhook"`
+	Enabled bool
+}
+
+type WebhookRequest struct {
+	URL    string   `validate:"required,url,max=2000"`
+	Events []string `validate:"required,min=1,dive,required"`
+	// Global webhooks receive the events of every user, only admins can create them
+	Global bool
+}
+
+type WebhookUpdateRequest struct {
+	URL    *string  `validate:"omitempty,url,max=2000"`
+	Events []string `validate:"omitempty,min=1,dive,required"`
+	// Enabling a webhook that was disabled after failing resets its failure count
+	Enabled *bool
+}
+
+type WebhookResponse struct {
+	ID                  uint
+	URL                 string
+	Events              []string
+	Global              bool
+	Disabled            bool
+	ConsecutiveFailures int
+	// The key payloads are signed with, only returned when the webhook is created
+	Secret    string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+type WebhookDeliveryResponse struct {
+	ID             uint
+	EventID        uint
+	Event          string
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	ResponseBody   string
+	LastError      string
+	NextAttemptAt  time.Time
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+type JobResponse struct {
+	ID          uint
+	Queue       string
+	Type        string
+	Payload     json.RawMessage
+	Status      string
+	RunAt       time.Time
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	CreatedAt   time.Time
+}
+
+type DataExportResponse struct {
+	ID          uint
+	Status      string
+	Size        int64
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+	// Signed link to the archive, only set while it can be downloaded
+	DownloadURL string `json:",omitempty"`
+}
+
+type JobCount struct {
+	Queue  string
+	Status string
+	Count  int64
+}
+
+type TrendingHashtag struct {
+	Name      string
+	PostCount int64
+}
+
+type UsernameRequest struct {
+	Username string `validate:"required,min=3,max=30"`
+}
+
+type GroupRequest struct {
+	Title   string `validate:"required,max=75"`
+	Slug    string `validate:"required,min=3,max=100"`
+	Summary string `validate:"max=500"`
+	Content string `validate:"max=5000"`
+}
+
+type GroupResponse struct {
+	ID          uint
+	Title       string
+	Slug        string
+	Summary     string
+	Content     string
+	CreatedByID uint
+	MemberCount int64
+	IsMember    bool
+	CreatedAt   time.Time
+}
+
+type GroupMessageResponse struct {
+	ID          uint
+	GroupID     uint
+	UserID      uint
+	Message     string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Attachments []AttachmentResponse
+}
+
+type AttachmentResponse struct {
+	ID          uint
+	ContentType string
+	Size        int64
+	Width       int `json:",omitempty"`
+	Height      int `json:",omitempty"`
+	URL         string
+	Variants    []AttachmentVariantResponse
+}
+
+type AttachmentVariantResponse struct {
+	Name   string
+	Width  int
+	Height int
+	URL    string
+}
+
+type PostResponse struct {
+	ID        uint
+	UserID    uint
+	SenderID  uint
+	Message   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Number of reactions of every kind, and the kind the requesting user reacted with, if any
+	Reactions  map[string]int64
+	MyReaction string `json:",omitempty"`
+
+	CommentCount int64
+	Attachments  []AttachmentResponse
+}
+
+type CommentRequest struct {
+	Message  string `validate:"required,max=1000"`
+	ParentID *uint
+}
+
+type CommentResponse struct {
+	ID         uint
+	PostID     uint
+	ParentID   *uint `json:",omitempty"`
+	Author     UserProfile
+	Message    string
+	ReplyCount int64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type ReactionRequest struct {
+	Kind string `validate:"required,max=20"`
+}
+
+type ReactorResponse struct {
+	Profile   UserProfile
+	Kind      string
+	ReactedAt time.Time
+}
+
+type PrivacySettings struct {
+	EmailVisibility       int `validate:"required,min=1,max=4"`
+	MobileVisibility      int `validate:"required,min=1,max=4"`
+	IntroVisibility       int `validate:"required,min=1,max=4"`
+	ConnectionsVisibility int `validate:"required,min=1,max=4"`
+	IsPrivate             bool
+}
+
+type UserSearchResult struct {
+	ID              uint
+	FirstName       string
+	MiddleName      string
+	LastName        string
+	Intro           string `json:",omitempty"`
+	IntroVisibility int    `json:"-"`
+	AvatarKey       string `json:"-"`
+	AvatarURL       string `json:",omitempty"`
+	Rank            float64
+	Highlight       string
+	IntroHighlight  string `json:",omitempty"`
+}
+
+type PostSearchResult struct {
+	ID        uint
+	UserID    uint
+	SenderID  uint
+	Message   string
+	CreatedAt time.Time
+	Rank      float64
+	Highlight string
 }
 
 type QueryFunc func(*gorm.DB) *gorm.DB
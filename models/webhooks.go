@@ -0,0 +1,35 @@
+package models
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Webhooks failing this many attempts in a row are disabled until their owner enables them again
+const WebhookDisableAfterFailures = 15
+
+// Reports whether the user is an admin.
+func IsAdmin(db *gorm.DB, userID uint) (bool, error) {
+	var admins int64
+	err := db.Model(&User{}).Where("id = ? AND is_admin", userID).Count(&admins).Error
+	return admins > 0, err
+}
+
+// Returns the names of the events the webhook receives.
+func (w Webhook) EventNames() []string {
+	if w.Events == "" {
+		return nil
+	}
+	return strings.Split(w.Events, ",")
+}
+
+// Reports whether the webhook receives events with this name.
+func (w Webhook) ReceivesEvent(name string) bool {
+	for _, eventName := range w.EventNames() {
+		if eventName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+)
+
+// ErrSubscriptionGone is returned by a Channel when the receiving side no longer exists, so the subscription should
+// be disabled instead of retried.
+var ErrSubscriptionGone = errors.New("subscription is gone")
+
+// Payload is what gets sent over every channel for a notification.
+type Payload struct {
+	ID          uint
+	Type        string
+	SubjectType string
+	SubjectID   uint
+	ActorID     uint
+	Summary     string
+	CreatedAt   time.Time
+}
+
+// Channel delivers notifications outside the app. Implementations must be safe for concurrent use.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, subscription models.NotificationSubscription, payload Payload) error
+}
+
+// Returns the payload of a single notification. Its Actor has to be loaded for the summary to name them.
+func NewPayload(notification models.Notification) Payload {
+	actorName := strings.TrimSpace(notification.Actor.FirstName + " " + notification.Actor.LastName)
+	var actorNames []string
+	if actorName != "" {
+		actorNames = []string{actorName}
+	}
+	return Payload{
+		ID:          notification.ID,
+		Type:        notification.Type,
+		SubjectType: notification.SubjectType,
+		SubjectID:   notification.SubjectID,
+		ActorID:     notification.ActorID,
+		Summary:     Summarize(notification.Type, actorNames, 1),
+		CreatedAt:   notification.CreatedAt,
+	}
+}
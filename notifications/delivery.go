@@ -0,0 +1,183 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Failed deliveries are retried after 30s, 1m, 2m and so on, up to this many attempts in total
+const (
+	MaxDeliveryAttempts = 8
+	initialBackoff      = 30 * time.Second
+	maxBackoff          = time.Hour
+)
+
+// Deliveries claimed by a dispatcher that did not finish within this time are picked up again
+const deliveryLockTimeout = time.Minute
+
+// Creates a pending delivery for every active subscription of the recipients, unless they turned the notification
+// type off for that channel. The notifications of a single Notify call all have the same type.
+func queueDeliveries(tx *gorm.DB, notifications []models.Notification) error {
+	recipientIDs := make([]uint, 0, len(notifications))
+	for _, notification := range notifications {
+		recipientIDs = append(recipientIDs, notification.UserID)
+	}
+
+	var subscriptions []models.NotificationSubscription
+	if err := tx.Where("user_id IN ? AND disabled_at IS NULL", recipientIDs).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+	var disabled []models.NotificationPreference
+	err := tx.Where("user_id IN ? AND type = ? AND NOT enabled", recipientIDs, notifications[0].Type).Find(&disabled).Error
+	if err != nil {
+		return err
+	}
+	off := map[uint]map[string]bool{}
+	for _, preference := range disabled {
+		if off[preference.UserID] == nil {
+			off[preference.UserID] = map[string]bool{}
+		}
+		off[preference.UserID][preference.Channel] = true
+	}
+
+	now := time.Now()
+	var deliveries []models.NotificationDelivery
+	for _, notification := range notifications {
+		for _, subscription := range subscriptions {
+			if subscription.UserID != notification.UserID || off[notification.UserID][subscription.Channel] {
+				continue
+			}
+			deliveries = append(deliveries, models.NotificationDelivery{
+				NotificationID: notification.ID,
+				SubscriptionID: subscription.ID,
+				Status:         models.DeliveryPending,
+				NextAttemptAt:  now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Create(&deliveries).Error
+}
+
+const claimDeliveriesQuery = `
+UPDATE notification_deliveries SET locked_until = ?
+WHERE id IN (
+	SELECT id FROM notification_deliveries
+	WHERE status = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+	ORDER BY next_attempt_at
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// Dispatcher sends queued deliveries over their channels. Several dispatchers may run at once, in one or more
+// processes, since every delivery is claimed before it is sent.
+type Dispatcher struct {
+	DB       *gorm.DB
+	Channels map[string]Channel
+}
+
+// Sends up to limit deliveries that are due and returns how many were attempted.
+func (d *Dispatcher) DeliverDue(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+	var deliveries []models.NotificationDelivery
+	err := d.DB.Raw(claimDeliveriesQuery, now.Add(deliveryLockTimeout), models.DeliveryPending, now, now, limit).
+		Scan(&deliveries).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, delivery); err != nil {
+			log.Printf("Could not record notification delivery %d: %v", delivery.ID, err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// Attempts a single delivery and records the outcome. The returned error is about recording it, failed sends are
+// handled by scheduling a retry.
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.NotificationDelivery) error {
+	var subscription models.NotificationSubscription
+	if err := d.DB.First(&subscription, delivery.SubscriptionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return d.fail(delivery, "subscription was removed")
+		}
+		return err
+	}
+	if subscription.DisabledAt != nil {
+		return d.fail(delivery, "subscription is disabled")
+	}
+	channel, ok := d.Channels[subscription.Channel]
+	if !ok {
+		return d.retry(delivery, "channel "+subscription.Channel+" is not configured")
+	}
+
+	var notification models.Notification
+	if err := d.DB.Preload("Actor").First(&notification, delivery.NotificationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return d.fail(delivery, "notification was removed")
+		}
+		return err
+	}
+	payload := NewPayload(notification)
+
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	err := channel.Send(sendCtx, subscription, payload)
+	switch {
+	case err == nil:
+		now := time.Now()
+		return d.DB.Model(&delivery).Updates(map[string]interface{}{
+			"status":       models.DeliveryDelivered,
+			"attempts":     delivery.Attempts + 1,
+			"delivered_at": &now,
+			"locked_until": nil,
+			"last_error":   "",
+		}).Error
+	case errors.Is(err, ErrSubscriptionGone):
+		if err := d.DB.Model(&subscription).Update("disabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return d.fail(delivery, err.Error())
+	default:
+		return d.retry(delivery, err.Error())
+	}
+}
+
+// Schedules the next attempt with exponential backoff, or gives up after MaxDeliveryAttempts.
+func (d *Dispatcher) retry(delivery models.NotificationDelivery, reason string) error {
+	attempts := delivery.Attempts + 1
+	if attempts >= MaxDeliveryAttempts {
+		return d.fail(delivery, reason)
+	}
+	backoff := initialBackoff << (attempts - 1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return d.DB.Model(&delivery).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": time.Now().Add(backoff),
+		"locked_until":    nil,
+		"last_error":      reason,
+	}).Error
+}
+
+func (d *Dispatcher) fail(delivery models.NotificationDelivery, reason string) error {
+	return d.DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":       models.DeliveryFailed,
+		"attempts":     delivery.Attempts + 1,
+		"locked_until": nil,
+		"last_error":   reason,
+	}).Error
+}
@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// What the actors of each notification type did, used to describe grouped notifications
+var verbs = map[string]string{
+	models.NotificationFollow:         "followed you",
+	models.NotificationFollowRequest:  "requested to follow you",
+	models.NotificationFollowAccepted: "accepted your follow request",
+	models.NotificationFriendRequest:  "sent you a friend request",
+	models.NotificationFriendAccepted: "accepted your friend request",
+	models.NotificationMessage:        "sent you a message",
+	models.NotificationComment:        "commented on your post",
+	models.NotificationReply:          "replied to your comment",
+	models.NotificationGroupJoin:      "joined your group",
+	models.NotificationMention:        "mentioned you",
+}
+
+// Summaries of the notifications about the recipient's own account, which have no other actor
+var accountSummaries = map[string]string{
+	models.NotificationDataExport: "Your data export is ready to download",
+}
+
+// Notify stores a notification for every recipient, except the actor, and queues its delivery to the recipient's
+// subscriptions. It takes the transaction of the change that caused it, so a notification is never sent for something
+// that was rolled back.
+func Notify(tx *gorm.DB, recipientIDs []uint, actorID uint, notificationType string, subjectType string, subjectID uint) error {
+	notifications := make([]models.Notification, 0, len(recipientIDs))
+	for _, recipientID := range recipientIDs {
+		if recipientID == actorID {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			UserID:      recipientID,
+			ActorID:     actorID,
+			Type:        notificationType,
+			SubjectType: subjectType,
+			SubjectID:   subjectID,
+		})
+	}
+	return create(tx, notifications)
+}
+
+// Notifies a single recipient about something that happened to them, using them as the subject.
+func NotifyUser(tx *gorm.DB, recipientID uint, actorID uint, notificationType string) error {
+	return Notify(tx, []uint{recipientID}, actorID, notificationType, models.SubjectUser, recipientID)
+}
+
+// Notifies a user about something that happened to their own account, like a finished data export. The recipient
+// is stored as the actor.
+func NotifyAccount(tx *gorm.DB, recipientID uint, notificationType string, subjectType string, subjectID uint) error {
+	return create(tx, []models.Notification{{
+		UserID:      recipientID,
+		ActorID:     recipientID,
+		Type:        notificationType,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+	}})
+}
+
+// Stores the notifications and queues their deliveries. Deactivated and deleted users get none, there is no one
+// to read them.
+func create(tx *gorm.DB, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	recipientIDs := make([]uint, 0, len(notifications))
+	for _, notification := range notifications {
+		recipientIDs = append(recipientIDs, notification.UserID)
+	}
+	var deactivatedIDs []uint
+	err := models.DeactivatedUserIDs(tx).Where("id IN ?", recipientIDs).Pluck("id", &deactivatedIDs).Error
+	if err != nil {
+		return err
+	}
+	notifications = slices.DeleteFunc(notifications, func(notification models.Notification) bool {
+		return slices.Contains(deactivatedIDs, notification.UserID)
+	})
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := tx.Create(&notifications).Error; err != nil {
+		return err
+	}
+	return queueDeliveries(tx, notifications)
+}
+
+// Describes a group of notifications of the same type, like "Ana Horvat and 4 others followed you". actorNames holds
+// the names of the most recent actors and actorCount the number of distinct actors in the group.
+func Summarize(notificationType string, actorNames []string, actorCount int64) string {
+	if summary, ok := accountSummaries[notificationType]; ok {
+		return summary
+	}
+	verb, ok := verbs[notificationType]
+	if !ok {
+		verb = notificationType
+	}
+	switch {
+	case len(actorNames) == 0:
+		return fmt.Sprintf("Someone %s", verb)
+	case actorCount == 1:
+		return fmt.Sprintf("%s %s", actorNames[0], verb)
+	case actorCount == 2 && len(actorNames) >= 2:
+		return fmt.Sprintf("%s and %s %s", actorNames[0], actorNames[1], verb)
+	case actorCount == 2:
+		return fmt.Sprintf("%s and 1 other %s", actorNames[0], verb)
+	default:
+		return fmt.Sprintf("%s and %d others %s", actorNames[0], actorCount-1, verb)
+	}
+}
@@ -0,0 +1,43 @@
+package notifications
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/safehttp"
+)
+
+// The channels notifications can be delivered over, by name
+var Channels = map[string]Channel{}
+
+var webPush *WebPushChannel
+
+// SetupChannels configures the delivery channels. Webhooks are always available, Web Push only when
+// VAPID_PRIVATE_KEY holds a base64url encoded P-256 private key. VAPID_SUBJECT should be a mailto: or https: URL
+// push services can reach us at.
+func SetupChannels() {
+	client := safehttp.NewClient(30 * time.Second)
+	Channels[models.ChannelWebhook] = &WebhookChannel{Client: client}
+
+	privateKey := os.Getenv("VAPID_PRIVATE_KEY")
+	if privateKey == "" {
+		log.Println("VAPID_PRIVATE_KEY is not set, Web Push notifications are disabled")
+		return
+	}
+	channel, err := NewWebPushChannel(client, privateKey, os.Getenv("VAPID_SUBJECT"))
+	if err != nil {
+		log.Fatal("Invalid VAPID_PRIVATE_KEY. \n", err)
+	}
+	webPush = channel
+	Channels[channel.Name()] = channel
+}
+
+// Returns the key browsers subscribe to Web Push with, or an empty string when Web Push is not configured.
+func VAPIDPublicKey() string {
+	if webPush == nil {
+		return ""
+	}
+	return webPush.PublicKey()
+}
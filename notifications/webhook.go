@@ -0,0 +1,70 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+)
+
+// WebhookChannel posts notifications as JSON to a URL of the user's choosing. Every request carries an
+// X-Signature header with the HMAC-SHA256 of the timestamp and body, keyed with the subscription's secret, so the
+// receiver can check that it came from us:
+//
+//	X-Signature: t=<unix timestamp>,v1=<hex hmac of "<timestamp>.<body>">
+type WebhookChannel struct {
+	Client *http.Client
+}
+
+func (w *WebhookChannel) Name() string {
+	return models.ChannelWebhook
+}
+
+func (w *WebhookChannel) Send(ctx context.Context, subscription models.NotificationSubscription, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", "t="+timestamp+",v1="+SignWebhook(subscription.Secret, timestamp, body))
+	req.Header.Set("X-Notification-Type", payload.Type)
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	default:
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+}
+
+// Returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed with secret.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+)
+
+func TestSignWebhook(t *testing.T) {
+	got := SignWebhook("secret", "1700000000", []byte(`{"id":1}`))
+	if want := "3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11"; got != want {
+		t.Errorf("SignWebhook() = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookChannelSend(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	channel := &WebhookChannel{Client: server.Client()}
+	subscription := models.NotificationSubscription{Endpoint: server.URL, Secret: "secret"}
+	payload := Payload{ID: 4, Type: "friend_request", SubjectType: "user", SubjectID: 2, ActorID: 2, Summary: "Ana sent you a friend request"}
+	if err := channel.Send(context.Background(), subscription, payload); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request = %s with Content-Type %q", req.Method, req.Header.Get("Content-Type"))
+	}
+	if got := req.Header.Get("X-Notification-Type"); got != payload.Type {
+		t.Errorf("X-Notification-Type = %q, want %q", got, payload.Type)
+	}
+	var received Payload
+	if err := json.Unmarshal(body, &received); err != nil || received != payload {
+		t.Errorf("body = %s, want %+v", body, payload)
+	}
+
+	timestamp, mac, ok := strings.Cut(strings.TrimPrefix(req.Header.Get("X-Signature"), "t="), ",v1=")
+	if !ok {
+		t.Fatalf("X-Signature = %q", req.Header.Get("X-Signature"))
+	}
+	if unix, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(unix, 0)) > time.Minute {
+		t.Errorf("signature timestamp = %q", timestamp)
+	}
+	if want := SignWebhook("secret", timestamp, body); mac != want {
+		t.Errorf("signature = %q, want %q", mac, want)
+	}
+}
+
+func TestWebhookChannelSendFailure(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr error
+	}{
+		{http.StatusGone, ErrSubscriptionGone},
+		{http.StatusNotFound, nil},
+		{http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			channel := &WebhookChannel{Client: server.Client()}
+			err := channel.Send(context.Background(), models.NotificationSubscription{Endpoint: server.URL}, Payload{})
+			if err == nil {
+				t.Fatal("Send() error = nil")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Send() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrSubscriptionGone) {
+				t.Errorf("Send() error = %v, want a retryable error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Record size announced in the aes128gcm header. Payloads are far smaller, so they always fit in one record.
+const webPushRecordSize = 4096
+
+// Push services keep undelivered messages for this long
+const webPushTTL = 24 * time.Hour
+
+// WebPushChannel sends notifications to browsers through their push service, encrypted as described in RFC 8291 and
+// authenticated with VAPID (RFC 8292).
+type WebPushChannel struct {
+	Client *http.Client
+	// Contact for the push service, a mailto: or https: URL
+	Subject    string
+	privateKey *ecdsa.PrivateKey
+	publicKey  []byte
+}
+
+// Creates the channel from a base64url encoded P-256 private key, as generated by most VAPID tools.
+func NewWebPushChannel(client *http.Client, privateKey string, subject string) (*WebPushChannel, error) {
+	scalar, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.P256().NewPrivateKey(scalar)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := key.PublicKey().Bytes()
+	x, y := elliptic.Unmarshal(elliptic.P256(), publicKey)
+	return &WebPushChannel{
+		Client:  client,
+		Subject: subject,
+		privateKey: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+			D:         new(big.Int).SetBytes(scalar),
+		},
+		publicKey: publicKey,
+	}, nil
+}
+
+// Returns the application server key browsers need to subscribe, base64url encoded.
+func (w *WebPushChannel) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(w.publicKey)
+}
+
+func (w *WebPushChannel) Name() string {
+	return models.ChannelWebPush
+}
+
+func (w *WebPushChannel) Send(ctx context.Context, subscription models.NotificationSubscription, payload Payload) error {
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	body, err := encryptWebPush(plaintext, subscription.P256dh, subscription.Auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := w.vapidAuthorization(subscription.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(webPushTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	default:
+		return fmt.Errorf("push service responded with %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+}
+
+// Returns the VAPID authorization header for the push service the endpoint belongs to.
+func (w *WebPushChannel) vapidAuthorization(endpoint string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": endpointURL.Scheme + "://" + endpointURL.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": w.Subject,
+	})
+	signed, err := token.SignedString(w.privateKey)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + signed + ", k=" + w.PublicKey(), nil
+}
+
+// Encrypts the payload for a browser with the aes128gcm content encoding of RFC 8188, using the key derivation of
+// RFC 8291.
+func encryptWebPush(plaintext []byte, p256dh string, auth string) ([]byte, error) {
+	receiverKeyBytes, err := base64.RawURLEncoding.DecodeString(trimPadding(p256dh))
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(trimPadding(auth))
+	if err != nil {
+		return nil, err
+	}
+	receiverKey, err := ecdh.P256().NewPublicKey(receiverKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(authSecret) != 16 {
+		return nil, errors.New("push subscription auth secret must be 16 bytes")
+	}
+
+	// A fresh key pair and salt for every message
+	senderKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return sealWebPush(plaintext, receiverKey, authSecret, senderKey, salt)
+}
+
+// Encrypts the payload as a single record with the given sender key and salt.
+func sealWebPush(plaintext []byte, receiverKey *ecdh.PublicKey, authSecret []byte, senderKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	sharedSecret, err := senderKey.ECDH(receiverKey)
+	if err != nil {
+		return nil, err
+	}
+	receiverKeyBytes := receiverKey.Bytes()
+	senderPublicKey := senderKey.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), receiverKeyBytes...)
+	keyInfo = append(keyInfo, senderPublicKey...)
+	ikm, err := hkdfExpand(hkdf.Extract(sha256.New, sharedSecret, authSecret), keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentKey, err := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// The 0x02 delimiter marks the last and only record
+	record := append(append([]byte{}, plaintext...), 0x02)
+	if len(record)+gcm.Overhead() > webPushRecordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	header := make([]byte, 0, 16+4+1+len(senderPublicKey))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(senderPublicKey)))
+	header = append(header, senderPublicKey...)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+func hkdfExpand(prk []byte, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), out)
+	return out, err
+}
+
+// Browsers hand out the subscription keys base64url encoded, some with padding.
+func trimPadding(value string) string {
+	for len(value) > 0 && value[len(value)-1] == '=' {
+		value = value[:len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,226 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+func decodeBase64(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// Decrypts a push message the way a browser does, see RFC 8291.
+func decryptWebPush(t *testing.T, body []byte, receiverKey *ecdh.PrivateKey, authSecret []byte) []byte {
+	t.Helper()
+	if len(body) < 21 || len(body) < 21+int(body[20]) {
+		t.Fatalf("push message of %d bytes is too short", len(body))
+	}
+	salt, recordSize, keyLength := body[:16], binary.BigEndian.Uint32(body[16:]), int(body[20])
+	if recordSize != webPushRecordSize {
+		t.Errorf("record size = %d, want %d", recordSize, webPushRecordSize)
+	}
+	senderKey, err := ecdh.P256().NewPublicKey(body[21 : 21+keyLength])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedSecret, err := receiverKey.ECDH(senderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), receiverKey.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, senderKey.Bytes()...)
+	ikm, _ := hkdfExpand(hkdf.Extract(sha256.New, sharedSecret, authSecret), keyInfo, 32)
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentKey, _ := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce, _ := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	block, _ := aes.NewCipher(contentKey)
+	gcm, _ := cipher.NewGCM(block)
+	record, err := gcm.Open(nil, nonce, body[21+keyLength:], nil)
+	if err != nil {
+		t.Fatalf("could not decrypt push message: %v", err)
+	}
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		t.Fatalf("record %x does not end with the last record delimiter", record)
+	}
+	return record[:len(record)-1]
+}
+
+// The example of RFC 8291, appendix A
+func TestSealWebPushRFC8291(t *testing.T) {
+	senderKey, err := ecdh.P256().NewPrivateKey(decodeBase64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverKey, err := ecdh.P256().NewPublicKey(decodeBase64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := decodeBase64(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := decodeBase64(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := sealWebPush([]byte("When I grow up, I want to be a watermelon"), receiverKey, authSecret, senderKey, salt)
+	if err != nil {
+		t.Fatalf("sealWebPush() error = %v", err)
+	}
+	// The example uses a record size of 4096, like sealWebPush
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if got := base64.RawURLEncoding.EncodeToString(body); got != want {
+		t.Errorf("sealWebPush() = %s, want %s", got, want)
+	}
+
+	receiverPrivateKey, err := ecdh.P256().NewPrivateKey(decodeBase64(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptWebPush(t, body, receiverPrivateKey, authSecret); string(got) != "When I grow up, I want to be a watermelon" {
+		t.Errorf("decrypted = %q", got)
+	}
+}
+
+func TestEncryptWebPushInvalidKeys(t *testing.T) {
+	receiverKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	p256dh := base64.RawURLEncoding.EncodeToString(receiverKey.PublicKey().Bytes())
+	tests := []struct {
+		name         string
+		p256dh, auth string
+	}{
+		{"invalid base64", "not base64!", "BTBZMqHH6r4Tts7J_aSIgg"},
+		{"not a curve point", "BCVx", "BTBZMqHH6r4Tts7J_aSIgg"},
+		{"short auth secret", p256dh, "BTBZMqHH6r4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := encryptWebPush([]byte("{}"), tt.p256dh, tt.auth); err == nil {
+				t.Error("encryptWebPush() error = nil")
+			}
+		})
+	}
+	if _, err := encryptWebPush(bytes.Repeat([]byte("a"), webPushRecordSize), p256dh, "BTBZMqHH6r4Tts7J_aSIgg"); err == nil {
+		t.Error("encryptWebPush() of an oversized payload error = nil")
+	}
+}
+
+func TestWebPushChannelSend(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	vapidKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	channel, err := NewWebPushChannel(server.Client(), base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()), "mailto:admin@example.com")
+	if err != nil {
+		t.Fatalf("NewWebPushChannel() error = %v", err)
+	}
+	if got := channel.PublicKey(); got != base64.RawURLEncoding.EncodeToString(vapidKey.PublicKey().Bytes()) {
+		t.Errorf("PublicKey() = %q", got)
+	}
+
+	receiverKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+	subscription := models.NotificationSubscription{
+		Endpoint: server.URL + "/push/abc",
+		// Browsers may send the keys padded
+		P256dh: base64.URLEncoding.EncodeToString(receiverKey.PublicKey().Bytes()),
+		Auth:   base64.URLEncoding.EncodeToString(authSecret),
+	}
+	payload := Payload{ID: 9, Type: "post_comment", Summary: "Ana commented on your post"}
+	if err := channel.Send(context.Background(), subscription, payload); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if req.URL.Path != "/push/abc" || req.Header.Get("Content-Encoding") != "aes128gcm" || req.Header.Get("TTL") != "86400" {
+		t.Errorf("request = %s with headers %v", req.URL.Path, req.Header)
+	}
+	var received Payload
+	if err := json.Unmarshal(decryptWebPush(t, body, receiverKey, authSecret), &received); err != nil || received != payload {
+		t.Errorf("payload = %+v, want %+v", received, payload)
+	}
+
+	// VAPID: a JWT signed with the channel's key for the push service's origin, and that key
+	token, key, ok := strings.Cut(strings.TrimPrefix(req.Header.Get("Authorization"), "vapid t="), ", k=")
+	if !ok || key != channel.PublicKey() {
+		t.Fatalf("Authorization = %q", req.Header.Get("Authorization"))
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return &channel.privateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience(server.URL))
+	if err != nil {
+		t.Fatalf("VAPID token is invalid: %v", err)
+	}
+	if claims["sub"] != "mailto:admin@example.com" || claims["exp"] == nil {
+		t.Errorf("claims = %v", claims)
+	}
+}
+
+func TestWebPushChannelSendFailure(t *testing.T) {
+	tests := []struct {
+		status int
+		gone   bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusGone, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+	vapidKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	receiverKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("unavailable\n"))
+			}))
+			defer server.Close()
+
+			channel, err := NewWebPushChannel(server.Client(), base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()), "mailto:admin@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			subscription := models.NotificationSubscription{
+				Endpoint: server.URL,
+				P256dh:   base64.RawURLEncoding.EncodeToString(receiverKey.PublicKey().Bytes()),
+				Auth:     "BTBZMqHH6r4Tts7J_aSIgg",
+			}
+			err = channel.Send(context.Background(), subscription, Payload{})
+			if err == nil {
+				t.Fatal("Send() error = nil")
+			}
+			if gone := errors.Is(err, ErrSubscriptionGone); gone != tt.gone {
+				t.Errorf("Send() error = %v, want gone %v", err, tt.gone)
+			}
+			if !tt.gone && !strings.HasSuffix(err.Error(), ": unavailable") {
+				t.Errorf("Send() error = %q, want the response message", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// Failed jobs are retried after 10s, 20s, 40s and so on
+const (
+	initialBackoff = 10 * time.Second
+	maxBackoff     = time.Hour
+)
+
+// How long a job may run. Its lock expires a minute later, after which another worker may pick it up.
+const jobTimeout = 10 * time.Minute
+
+const claimJobQuery = `
+UPDATE jobs SET status = ?, attempts = attempts + 1, locked_until = ?, started_at = ?
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE queue = ? AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?))
+	ORDER BY run_at, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// Pool runs the jobs of one queue with a fixed number of workers. Every job is claimed before it runs, so pools of
+// the same queue may run in several processes at once.
+type Pool struct {
+	DB          *gorm.DB
+	Queue       string
+	Concurrency int
+	// How long idle workers wait before looking for new jobs
+	PollInterval time.Duration
+}
+
+// Start starts the workers, which run until ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	for i := 0; i < p.Concurrency; i++ {
+		go p.work(ctx)
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, found, err := p.claim()
+		if err != nil {
+			log.Printf("Could not claim a job from queue %s: %v", p.Queue, err)
+		}
+		if err != nil || !found {
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.PollInterval):
+			}
+			continue
+		}
+		if err := p.run(ctx, job); err != nil {
+			log.Printf("Could not record the outcome of job %d: %v", job.ID, err)
+		}
+	}
+}
+
+func (p *Pool) claim() (models.Job, bool, error) {
+	now := time.Now()
+	var claimed []models.Job
+	err := p.DB.Raw(claimJobQuery, models.JobRunning, now.Add(jobTimeout+time.Minute), now,
+		p.Queue, models.JobPending, now, models.JobRunning, now).Scan(&claimed).Error
+	if err != nil || len(claimed) == 0 {
+		return models.Job{}, false, err
+	}
+	return claimed[0], true, nil
+}
+
+// Runs a claimed job and records the outcome. The returned error is about recording it, failing jobs are retried
+// or marked dead.
+func (p *Pool) run(ctx context.Context, job models.Job) error {
+	// Jobs claimed again after their lock expired already used up their attempts without recording a failure
+	if job.Attempts > job.MaxAttempts {
+		return p.finish(job, models.JobDead, "the job did not finish within its lock")
+	}
+	handler, ok := handlerFor(job.Type)
+	if !ok {
+		return p.finish(job, models.JobDead, "no handler for job type "+job.Type)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+	err := runHandler(runCtx, handler, job)
+	if err == nil {
+		return p.finish(job, models.JobSucceeded, "")
+	}
+
+	log.Printf("Job %d (%s) failed: %v", job.ID, job.Type, err)
+	if job.Attempts >= job.MaxAttempts {
+		return p.finish(job, models.JobDead, err.Error())
+	}
+	backoff := initialBackoff << (job.Attempts - 1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return p.locked(job).Updates(map[string]interface{}{
+		"status":       models.JobPending,
+		"run_at":       time.Now().Add(backoff),
+		"locked_until": nil,
+		"last_error":   err.Error(),
+	}).Error
+}
+
+// Runs the handler, turning a panic into an error so it does not take the worker down.
+func runHandler(ctx context.Context, handler Handler, job models.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v\n%s", recovered, debug.Stack())
+		}
+	}()
+	return handler(ctx, []byte(job.Payload))
+}
+
+func (p *Pool) finish(job models.Job, status string, reason string) error {
+	return p.locked(job).Updates(map[string]interface{}{
+		"status":       status,
+		"locked_until": nil,
+		"last_error":   reason,
+		"finished_at":  time.Now(),
+	}).Error
+}
+
+// Scopes an update to the job as long as this worker still holds it, so a worker that lost its lock does not
+// overwrite the outcome of the one that took over.
+func (p *Pool) locked(job models.Job) *gorm.DB {
+	return p.DB.Model(&models.Job{}).Where("id = ? AND status = ? AND attempts = ?", job.ID, models.JobRunning, job.Attempts)
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+)
+
+// The queue jobs go to unless they are enqueued with OnQueue
+const DefaultQueue = "default"
+
+// Attempts a job gets unless it is enqueued with MaxAttempts
+const DefaultMaxAttempts = 5
+
+// Handler runs a job with its JSON encoded payload. Jobs can run more than once, when a worker crashes or loses its
+// lock, so handlers have to be idempotent.
+type Handler func(ctx context.Context, payload []byte) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]Handler{}
+)
+
+// Handle registers the handler of a job type, decoding payloads into P. Handlers have to be registered before the
+// worker pools start.
+func Handle[P any](jobType string, handler func(ctx context.Context, payload P) error) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[jobType] = func(ctx context.Context, data []byte) error {
+		var payload P
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return fmt.Errorf("decoding payload: %w", err)
+		}
+		return handler(ctx, payload)
+	}
+}
+
+func handlerFor(jobType string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	handler, ok := handlers[jobType]
+	return handler, ok
+}
+
+// Option changes how a job is enqueued.
+type Option func(job *models.Job)
+
+// OnQueue puts the job on a queue other than DefaultQueue.
+func OnQueue(queue string) Option {
+	return func(job *models.Job) { job.Queue = queue }
+}
+
+// RunAt schedules the job for a later time.
+func RunAt(runAt time.Time) Option {
+	return func(job *models.Job) { job.RunAt = runAt }
+}
+
+// Delay schedules the job to run once delay has passed.
+func Delay(delay time.Duration) Option {
+	return func(job *models.Job) { job.RunAt = time.Now().Add(delay) }
+}
+
+// MaxAttempts sets how often the job is tried before it is given up on.
+func MaxAttempts(attempts int) Option {
+	return func(job *models.Job) { job.MaxAttempts = attempts }
+}
+
+// Enqueue stores a job. Passing the transaction of the change that needs the job makes sure the job only runs if
+// the change was committed.
+func Enqueue(tx *gorm.DB, jobType string, payload interface{}, options ...Option) (models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, err
+	}
+	job := models.Job{
+		Queue:       DefaultQueue,
+		Type:        jobType,
+		Payload:     string(data),
+		Status:      models.JobPending,
+		RunAt:       time.Now(),
+		MaxAttempts: DefaultMaxAttempts,
+	}
+	for _, option := range options {
+		option(&job)
+	}
+	err = tx.Create(&job).Error
+	return job, err
+}
+
+// Retry makes a dead or pending job run again right away with a fresh set of attempts. It reports false when the job
+// does not exist or is running or succeeded.
+func Retry(db *gorm.DB, jobID uint) (bool, error) {
+	result := db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", jobID, []string{models.JobDead, models.JobPending}).
+		Updates(map[string]interface{}{
+			"status":       models.JobPending,
+			"attempts":     0,
+			"run_at":       time.Now(),
+			"locked_until": nil,
+			"finished_at":  nil,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Deletes succeeded jobs that finished longer than retention ago. Dead jobs are kept until someone looks into them.
+func PurgeSucceeded(db *gorm.DB, retention time.Duration) error {
+	return db.Where("status = ? AND finished_at < ?", models.JobSucceeded, time.Now().Add(-retention)).
+		Delete(&models.Job{}).Error
+}
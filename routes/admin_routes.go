@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupAdminRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	adminRoutes := app.Group("/admin", protect_Route, handlers.RequireAdmin)
+	adminRoutes.Get("/jobs", handlers.GetJobs)
+	adminRoutes.Get("/jobs/stats", handlers.GetJobStats)
+	adminRoutes.Get("/jobs/:jobID", handlers.GetJob)
+	adminRoutes.Post("/jobs/:jobID/retry", handlers.RetryJob)
+	adminRoutes.Get("/tasks/runs", handlers.GetTaskRuns)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupGroupRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	groupRoutes := app.Group("/groups")
+	groupRoutes.Post("/", protect_Route, handlers.CreateGroup)
+	groupRoutes.Get("/:groupID", protect_Route, handlers.GetGroup)
+	groupRoutes.Post("/:groupID/members", protect_Route, handlers.JoinGroup)
+	groupRoutes.Delete("/:groupID/members", protect_Route, handlers.LeaveGroup)
+	groupRoutes.Get("/:groupID/messages", protect_Route, handlers.GetGroupMessages)
+	groupRoutes.Post("/:groupID/messages", protect_Route, handlers.SendGroupMessage)
+	groupRoutes.Patch("/:groupID/messages/:messageID", protect_Route, handlers.UpdateGroupMessage)
+}
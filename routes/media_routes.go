@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupMediaRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	mediaRoutes := app.Group("/media")
+	mediaRoutes.Post("/", protect_Route, handlers.UploadMedia)
+	mediaRoutes.Get("/:mediaID", protect_Route, handlers.GetMedia)
+	mediaRoutes.Get("/:mediaID/:variant", protect_Route, handlers.GetMedia)
+	mediaRoutes.Delete("/:mediaID", protect_Route, handlers.DeleteMedia)
+
+	// Signed URLs carry their own authorization, see storage.SignedURL
+	app.Get("/files/*", handlers.ServeSignedFile)
+}
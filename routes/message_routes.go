@@ -1 +1,17 @@
-package routes
\ No newline at end of file
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupMessageRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	messageRoutes := app.Group("/messages")
+	messageRoutes.Get("/:userID", protect_Route, handlers.GetConversation)
+	messageRoutes.Post("/:userID", protect_Route, handlers.SendMessage)
+}
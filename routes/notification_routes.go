@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupNotificationRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	notificationRoutes := app.Group("/notifications")
+	notificationRoutes.Get("/", protect_Route, handlers.GetNotifications)
+	notificationRoutes.Get("/unread-count", protect_Route, handlers.GetUnreadNotificationCount)
+	notificationRoutes.Post("/read-all", protect_Route, handlers.MarkAllNotificationsRead)
+	notificationRoutes.Get("/vapid-public-key", protect_Route, handlers.GetVAPIDPublicKey)
+	notificationRoutes.Get("/subscriptions", protect_Route, handlers.GetSubscriptions)
+	notificationRoutes.Post("/subscriptions", protect_Route, handlers.CreateSubscription)
+	notificationRoutes.Delete("/subscriptions/:subscriptionID", protect_Route, handlers.DeleteSubscription)
+	notificationRoutes.Get("/preferences", protect_Route, handlers.GetNotificationPreferences)
+	notificationRoutes.Put("/preferences", protect_Route, handlers.UpdateNotificationPreferences)
+	notificationRoutes.Post("/:notificationID/read", protect_Route, handlers.MarkNotificationRead)
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupPostRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+	app.Get("/timeline", protect_Route, handlers.GetTimeline)
+
+	userPostRoutes := app.Group("/users/:id/posts")
+	userPostRoutes.Get("/", protect_Route, handlers.GetUserPosts)
+	userPostRoutes.Post("/", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.CreatePost)
+	userPostRoutes.Patch("/:postID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UpdatePost)
+	userPostRoutes.Delete("/:postID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeletePost)
+
+	postRoutes := app.Group("/posts")
+	postRoutes.Get("/reaction-kinds", protect_Route, handlers.GetReactionKinds)
+	postRoutes.Get("/:postID/reactions", protect_Route, handlers.GetPostReactions)
+	postRoutes.Put("/:postID/reactions", protect_Route, handlers.ReactToPost)
+	postRoutes.Delete("/:postID/reactions", protect_Route, handlers.UnreactToPost)
+	postRoutes.Get("/:postID/comments", protect_Route, handlers.GetPostComments)
+	postRoutes.Post("/:postID/comments", protect_Route, handlers.CreateComment)
+
+	commentRoutes := app.Group("/comments")
+	commentRoutes.Get("/:commentID/replies", protect_Route, handlers.GetCommentReplies)
+	commentRoutes.Patch("/:commentID", protect_Route, handlers.UpdateComment)
+	commentRoutes.Delete("/:commentID", protect_Route, handlers.DeleteComment)
+
+	hashtagRoutes := app.Group("/hashtags")
+	hashtagRoutes.Get("/trending", protect_Route, handlers.GetTrendingHashtags)
+	hashtagRoutes.Get("/:tag/posts", protect_Route, handlers.GetHashtagPosts)
+}
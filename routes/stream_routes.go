@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupStreamRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	// EventSource cannot send headers, so the token may also be passed as the access_token query parameter
+	app.Get("/stream", middlewares.TokenFromQuery("access_token"), protect_Route, handlers.StreamEvents)
+}
@@ -15,17 +15,60 @@ func SetupUserRoutes(app *fiber.App) {
 	protect_Route := middlewares.NewAuthMiddleware(protect_Route_secret)
 	userRoutes := app.Group("/users")
 	userRoutes.Post("/create", handlers.CreateUser)
+	userRoutes.Post("/restore", handlers.RestoreUser)
 	userRoutes.Get("/", protect_Route, handlers.ListAllUsers)
-	userRoutes.Get("/:id", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetUserProfileByID)
+
+	// Views of the caller's own account, registered before "/:id" so "me" is not taken for an ID
+	userRoutes.Get("/me", protect_Route, handlers.GetMyProfile)
+	userRoutes.Get("/me/suggestions", protect_Route, handlers.GetSuggestions)
+	userRoutes.Post("/me/exports", protect_Route, handlers.RequestDataExport)
+	userRoutes.Get("/me/exports", protect_Route, handlers.GetDataExports)
+	userRoutes.Get("/me/exports/:exportID", protect_Route, handlers.GetDataExport)
+
+	// Views of any user, readable by every authenticated user subject to the target's privacy settings
+	userRoutes.Get("/:id", protect_Route, handlers.GetUserProfileByID)
+	userRoutes.Get("/:id/followers", protect_Route, handlers.GetUserFollowers)
+	userRoutes.Get("/:id/following", protect_Route, handlers.GetUserFollowing)
+	userRoutes.Get("/:id/relationship", protect_Route, handlers.GetRelationshipStatus)
+	userRoutes.Get("/:id/friends", protect_Route, handlers.GetUserFriends)
+	userRoutes.Get("/:id/mutual-friends", protect_Route, handlers.GetMutualFriends)
+	userRoutes.Get("/:id/mutual-followers", protect_Route, handlers.GetMutualFollowers)
+	userRoutes.Get("/:id/separation", protect_Route, handlers.GetDegreeOfSeparation)
+
+	// Mutations, which are only allowed on the caller's own account
 	userRoutes.Patch("/:id/update", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UpdateUserProfileByID)
 	userRoutes.Delete("/:id/delete", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeleteUserByID)
+	userRoutes.Post("/:id/deactivate", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeactivateUser)
+	userRoutes.Get("/:id/privacy", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetPrivacySettings)
+	userRoutes.Patch("/:id/privacy", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UpdatePrivacySettings)
+	userRoutes.Patch("/:id/username", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UpdateUsername)
+	userRoutes.Put("/:id/avatar", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UploadAvatar)
+	userRoutes.Delete("/:id/avatar", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeleteAvatar)
+	userRoutes.Put("/:id/cover", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UploadCover)
+	userRoutes.Delete("/:id/cover", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeleteCover)
 
-	userRoutes.Get("/:id/followers", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetUserFollowers)
+	userRoutes.Post("/:id/following/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.FollowUser)
+	userRoutes.Delete("/:id/following/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UnfollowUser)
+	// Kept for existing clients, these make :id follow or unfollow :targetID just like the routes above
 	userRoutes.Post("/:id/followers/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.FollowUser)
 	userRoutes.Delete("/:id/followers/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UnfollowUser)
 
-	userRoutes.Get("/:id/friends", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetUserFriends)
+	userRoutes.Get("/:id/follow-requests", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetFollowRequests)
+	userRoutes.Post("/:id/follow-requests/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.ApproveFollowRequest)
+	userRoutes.Delete("/:id/follow-requests/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.RejectFollowRequest)
+
 	userRoutes.Post("/:id/friends/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.CreateUserFriends)
 	userRoutes.Delete("/:id/friends/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeleteUserFriends)
+	userRoutes.Get("/:id/friend-requests", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetFriendRequests)
+	userRoutes.Post("/:id/friend-requests/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.AcceptFriendRequest)
+	userRoutes.Delete("/:id/friend-requests/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.DeleteUserFriends)
+
+	userRoutes.Get("/:id/blocks", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetBlockedUsers)
+	userRoutes.Post("/:id/blocks/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.BlockUser)
+	userRoutes.Delete("/:id/blocks/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UnblockUser)
+
+	userRoutes.Get("/:id/mutes", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.GetMutedUsers)
+	userRoutes.Post("/:id/mutes/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.MuteUser)
+	userRoutes.Delete("/:id/mutes/:targetID", protect_Route, middlewares.CompareJWTandUserIDMiddleware(), handlers.UnmuteUser)
 
 }
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/coaltail/GoOrders/handlers"
+	"github.com/coaltail/GoOrders/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupWebhookRoutes(app *fiber.App) {
+	protect_Route := middlewares.NewAuthMiddleware(os.Getenv("JWT_SECRET"))
+
+	webhookRoutes := app.Group("/webhooks")
+	webhookRoutes.Get("/", protect_Route, handlers.GetWebhooks)
+	webhookRoutes.Post("/", protect_Route, handlers.CreateWebhook)
+	webhookRoutes.Get("/events", protect_Route, handlers.GetWebhookEventTypes)
+	webhookRoutes.Patch("/:webhookID", protect_Route, handlers.UpdateWebhook)
+	webhookRoutes.Delete("/:webhookID", protect_Route, handlers.DeleteWebhook)
+	webhookRoutes.Get("/:webhookID/deliveries", protect_Route, handlers.GetWebhookDeliveries)
+	webhookRoutes.Post("/:webhookID/deliveries/:deliveryID/redeliver", protect_Route, handlers.RedeliverWebhookDelivery)
+}
@@ -0,0 +1,54 @@
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateAddress = errors.New("requests to private network addresses are not allowed")
+
+// NewClient returns an HTTP client for requests to URLs chosen by users, such as webhooks. It refuses to connect to
+// loopback, private and link-local addresses, so those URLs cannot be used to reach internal services. The address
+// is checked when connecting, after DNS resolution, so a hostname cannot be re-pointed at an internal address
+// between validation and use. Setting OUTBOUND_ALLOW_PRIVATE_NETWORKS=true lifts the restriction, which is needed
+// to test against stand-ins running locally.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			if os.Getenv("OUTBOUND_ALLOW_PRIVATE_NETWORKS") == "true" {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// Redirects are not followed, the target should be configured with its final URL
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. It has the usual five fields, minute, hour, day of month, month and day of
+// week, each a "*", a number, a range "a-b" or a comma separated list of those, optionally with a "/step". Sunday is
+// both 0 and 7. The shortcuts @hourly, @daily, @weekly and @monthly are understood as well.
+type Schedule struct {
+	minute, hour, day, month, weekday uint64
+	// When both days are restricted, either of them matching is enough, like in cron
+	dayRestricted, weekdayRestricted bool
+}
+
+var shortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+type fieldBounds struct {
+	name     string
+	min, max int
+}
+
+var fields = []fieldBounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a cron expression.
+func Parse(expression string) (Schedule, error) {
+	if shortcut, ok := shortcuts[expression]; ok {
+		expression = shortcut
+	}
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("cron expression %q must have %d fields", expression, len(fields))
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, fmt.Errorf("cron expression %q: %w", expression, err)
+		}
+		sets[i] = set
+	}
+	// Sunday can be written as 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return Schedule{
+		minute:            sets[0],
+		hour:              sets[1],
+		day:               sets[2],
+		month:             sets[3],
+		weekday:           sets[4],
+		dayRestricted:     !strings.HasPrefix(parts[2], "*"),
+		weekdayRestricted: !strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// Returns the values a field matches as a bit set.
+func parseField(field string, bounds fieldBounds) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			var err error
+			rangePart = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", bounds.name, item)
+			}
+		}
+
+		low, high := bounds.min, bounds.max
+		if rangePart != "*" {
+			var err error
+			if i := strings.IndexByte(rangePart, '-'); i >= 0 {
+				low, err = strconv.Atoi(rangePart[:i])
+				if err == nil {
+					high, err = strconv.Atoi(rangePart[i+1:])
+				}
+			} else {
+				low, err = strconv.Atoi(rangePart)
+				high = low
+				// "5/15" means every 15 starting at 5
+				if step > 1 {
+					high = bounds.max
+				}
+			}
+			if err != nil || low < bounds.min || high > bounds.max || low > high {
+				return 0, fmt.Errorf("invalid %s %q", bounds.name, item)
+			}
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first time after t the schedule matches, in t's location.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid schedule matches within a few years, February 29th being the rarest day
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s Schedule) matchesDay(t time.Time) bool {
+	day := s.day&(1<<t.Day()) != 0
+	weekday := s.weekday&(1<<int(t.Weekday())) != 0
+	if s.dayRestricted && s.weekdayRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"@yearly",
+	} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Parse(%q) error = nil", expression)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2023, time.March, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expression string
+		want       []time.Time
+	}{
+		{"* * * * *", []time.Time{
+			time.Date(2023, time.March, 15, 10, 8, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 10, 9, 0, 0, time.UTC),
+		}},
+		{"*/15 * * * *", []time.Time{
+			time.Date(2023, time.March, 15, 10, 15, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 10, 30, 0, 0, time.UTC),
+		}},
+		// Every 15 minutes starting at 5
+		{"5/15 * * * *", []time.Time{
+			time.Date(2023, time.March, 15, 10, 20, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 10, 35, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 10, 50, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 11, 5, 0, 0, time.UTC),
+		}},
+		{"0,30 9-17/4 * * *", []time.Time{
+			time.Date(2023, time.March, 15, 13, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 13, 30, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 17, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 15, 17, 30, 0, 0, time.UTC),
+			time.Date(2023, time.March, 16, 9, 0, 0, 0, time.UTC),
+		}},
+		{"@daily", []time.Time{
+			time.Date(2023, time.March, 16, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 17, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@weekly", []time.Time{
+			time.Date(2023, time.March, 19, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 26, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@monthly", []time.Time{
+			time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		// Sunday written as 7
+		{"0 12 * * 7", []time.Time{
+			time.Date(2023, time.March, 19, 12, 0, 0, 0, time.UTC),
+		}},
+		// The 31st is skipped in months that are shorter
+		{"0 0 31 * *", []time.Time{
+			time.Date(2023, time.March, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.May, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.July, 31, 0, 0, 0, 0, time.UTC),
+		}},
+		// Only leap years have the 29th of February
+		{"0 0 29 2 *", []time.Time{
+			time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		}},
+		// With both days restricted either of them matches: the 20th, and every Monday
+		{"0 0 20 * 1", []time.Time{
+			time.Date(2023, time.March, 20, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 27, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.April, 3, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.April, 10, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.April, 17, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.April, 20, 0, 0, 0, 0, time.UTC),
+		}},
+		// With only one of them restricted, the other does not widen it
+		{"0 0 * * 1", []time.Time{
+			time.Date(2023, time.March, 20, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 27, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 */10 * *", []time.Time{
+			time.Date(2023, time.March, 21, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.March, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			schedule, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			next := from
+			for _, want := range tt.want {
+				next = schedule.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("Next() = %v, want %v", next, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleNextNever(t *testing.T) {
+	schedule, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if next := schedule.Next(time.Date(2023, time.March, 15, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("Next() = %v, want the zero time", next)
+	}
+}
+
+func TestScheduleNextLocation(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	schedule, err := Parse("30 1 * * *")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	next := schedule.Next(time.Date(2023, time.March, 15, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2023, time.March, 15, 1, 30, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Next() = %v, want %v", next, want)
+	}
+	// Midnight in UTC is already 2am there
+	next = schedule.Next(time.Date(2023, time.March, 15, 0, 0, 0, 0, time.UTC).In(location))
+	if want := time.Date(2023, time.March, 16, 1, 30, 0, 0, location); !next.Equal(want) {
+		t.Errorf("Next() = %v, want %v", next, want)
+	}
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type task struct {
+	name     string
+	schedule Schedule
+	run      func(ctx context.Context) error
+}
+
+// Scheduler runs tasks on cron schedules, evaluated in UTC. Every instance of the app runs the scheduler, a Postgres
+// advisory lock per task makes sure only one of them runs a task at a time, and the run history makes sure a
+// scheduled time is only run once.
+type Scheduler struct {
+	DB    *gorm.DB
+	tasks []task
+}
+
+func New(db *gorm.DB) *Scheduler {
+	return &Scheduler{DB: db}
+}
+
+// Add registers a task under a unique name. The name identifies the task in the run history and its lock, so it
+// should not change.
+func (s *Scheduler) Add(name string, expression string, run func(ctx context.Context) error) error {
+	schedule, err := Parse(expression)
+	if err != nil {
+		return err
+	}
+	s.tasks = append(s.tasks, task{name: name, schedule: schedule, run: run})
+	return nil
+}
+
+// Start runs every task on its schedule until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, t := range s.tasks {
+		go s.loop(ctx, t)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, t task) {
+	for {
+		next := t.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			log.Printf("Scheduled task %s never runs, its schedule matches no date", t.name)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.runOnce(ctx, t, next); err != nil {
+			log.Printf("Could not run scheduled task %s: %v", t.name, err)
+		}
+	}
+}
+
+// Runs the task for one scheduled time, unless another instance holds its lock or already ran it.
+func (s *Scheduler) runOnce(ctx context.Context, t task, scheduledAt time.Time) error {
+	// Advisory locks belong to a database session, so locking and unlocking have to use the same connection
+	return s.DB.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey(t.name)).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockKey(t.name))
+
+		instance, _ := os.Hostname()
+		run := models.TaskRun{
+			Task:        t.name,
+			ScheduledAt: scheduledAt,
+			Instance:    instance,
+			Status:      models.TaskRunning,
+			StartedAt:   time.Now(),
+		}
+		result := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		status, message := models.TaskSucceeded, ""
+		if err := t.run(ctx); err != nil {
+			log.Printf("Scheduled task %s failed: %v", t.name, err)
+			status, message = models.TaskFailed, err.Error()
+		}
+		return conn.Model(&run).Updates(map[string]interface{}{
+			"status":      status,
+			"error":       message,
+			"finished_at": time.Now(),
+		}).Error
+	})
+}
+
+// Returns the advisory lock key of a task. Keys are shared with everything else using advisory locks in the
+// database, hashing the name with a prefix keeps collisions unlikely.
+func lockKey(name string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte("scheduler:" + name))
+	return int64(hash.Sum64())
+}
+
+// Deletes the history of runs that were scheduled longer than retention ago.
+func PurgeRuns(db *gorm.DB, retention time.Duration) error {
+	return db.Where("scheduled_at < ?", time.Now().Add(-retention)).Delete(&models.TaskRun{}).Error
+}
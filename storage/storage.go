@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrNotFound is returned by a BlobStore when there is no blob under the requested key.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores uploaded files under slash separated keys. Keys are generated by the application, never taken
+// from user input.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var Store BlobStore
+
+// Setup picks the blob store from the STORAGE_DRIVER environment variable. "local" keeps files under
+// STORAGE_LOCAL_PATH, "s3" uses an S3 compatible service, see NewS3Store for its settings.
+func Setup() {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "local":
+		root := os.Getenv("STORAGE_LOCAL_PATH")
+		if root == "" {
+			root = "uploads"
+		}
+		store, err := NewLocalStore(root)
+		if err != nil {
+			log.Fatal("Failed to set up local storage. \n", err)
+		}
+		Store = store
+	case "s3":
+		store, err := NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+		if err != nil {
+			log.Fatal("Failed to set up S3 storage. \n", err)
+		}
+		Store = store
+	default:
+		log.Fatalf("Unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+// Reports whether key is a relative path without empty, "." or ".." segments.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
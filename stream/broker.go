@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Number of events kept per user, so that clients reconnecting with Last-Event-ID get what they missed
+const LogSize = 200
+
+// Events buffered for a subscriber. Subscribers falling further behind are disconnected and resume from the log
+// when they reconnect.
+const subscriberBuffer = 32
+
+// Event is a single server-sent event. IDs increase across all users and restarts, so a Last-Event-ID from before
+// a restart is never mistaken for a newer event.
+type Event struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+type userLog struct {
+	events []Event
+	// Events up to this ID may be missing from the log, because they were dropped or published before it existed
+	floor       uint64
+	subscribers map[chan Event]struct{}
+	// When the last subscriber left, used to drop logs nobody reads anymore
+	idleSince time.Time
+}
+
+// Broker keeps the recent events of every user that is, or was until recently, connected to the stream and hands
+// new ones to their subscribers. It lives in memory, so it only reaches clients connected to this process.
+type Broker struct {
+	mu     sync.Mutex
+	nextID uint64
+	logs   map[uint]*userLog
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		nextID: uint64(time.Now().UnixMicro()),
+		logs:   map[uint]*userLog{},
+	}
+}
+
+// The broker the stream endpoint and the publishers share
+var Default = NewBroker()
+
+// Publish sends an event to the users. Users without a log, i.e. users that were not connected recently, are
+// skipped since they load everything through the API when they connect.
+func (b *Broker) Publish(userIDs []uint, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, userID := range userIDs {
+		log, ok := b.logs[userID]
+		if !ok {
+			continue
+		}
+		b.nextID++
+		event := Event{ID: b.nextID, Type: eventType, Data: payload}
+		if len(log.events) == LogSize {
+			log.floor = log.events[0].ID
+			copy(log.events, log.events[1:])
+			log.events = log.events[:LogSize-1]
+		}
+		log.events = append(log.events, event)
+
+		for subscriber := range log.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				delete(log.subscribers, subscriber)
+				close(subscriber)
+			}
+		}
+	}
+	return nil
+}
+
+// Subscribe starts receiving the events of a user. The events after lastEventID still in the log are returned
+// first, complete is false when some of them were already dropped from it. The channel is closed when the subscriber
+// falls too far behind, and cancel has to be called once the subscriber is done.
+func (b *Broker) Subscribe(userID uint, lastEventID uint64) (missed []Event, complete bool, events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log, ok := b.logs[userID]
+	if !ok {
+		log = &userLog{floor: b.nextID, subscribers: map[chan Event]struct{}{}}
+		b.logs[userID] = log
+	}
+	subscriber := make(chan Event, subscriberBuffer)
+	log.subscribers[subscriber] = struct{}{}
+	log.idleSince = time.Time{}
+
+	complete = true
+	if lastEventID > 0 {
+		complete = lastEventID >= log.floor
+		for _, event := range log.events {
+			if event.ID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := log.subscribers[subscriber]; ok {
+			delete(log.subscribers, subscriber)
+			close(subscriber)
+		}
+		if len(log.subscribers) == 0 {
+			log.idleSince = time.Now()
+		}
+	}
+	return missed, complete, subscriber, cancel
+}
+
+// Prune drops the logs of users that have had no subscriber for longer than idle.
+func (b *Broker) Prune(idle time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for userID, log := range b.logs {
+		if len(log.subscribers) == 0 && !log.idleSince.IsZero() && time.Since(log.idleSince) > idle {
+			delete(b.logs, userID)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"gorm.io/gorm"
+)
+
+// Event types sent over the stream
+const (
+	EventNotification = "notification"
+	EventFollower     = "follower"
+	EventTimeline     = "timeline"
+	// Sent first when the events since Last-Event-ID are no longer all known, clients should reload what they show
+	EventReset = "reset"
+)
+
+// Actions of follower events
+const (
+	FollowAdded     = "added"
+	FollowRequested = "requested"
+	FollowRemoved   = "removed"
+)
+
+// FollowerEvent is sent to both users of a follow that was created, approved or removed.
+type FollowerEvent struct {
+	Action     string `json:"action"`
+	FollowerID uint   `json:"followerID"`
+	FolloweeID uint   `json:"followeeID"`
+}
+
+// TimelineEvent tells followers that a post showed up in their timeline. Clients load the post itself through the API.
+type TimelineEvent struct {
+	PostID   uint `json:"postID"`
+	UserID   uint `json:"userID"`
+	SenderID uint `json:"senderID"`
+}
+
+// Notifications are created in the transactions of whatever caused them, so instead of publishing them before it
+// is known whether they are committed, the table is polled. Rows are looked up this far back, which covers
+// transactions that commit after a newer one.
+const notificationLookback = 10 * time.Second
+
+// NotificationTail publishes committed notifications to the stream of their recipients.
+type NotificationTail struct {
+	DB     *gorm.DB
+	Broker *Broker
+	// Notifications already published, with when they were created
+	published map[uint]time.Time
+}
+
+// Poll publishes the notifications that were committed since the last call.
+func (t *NotificationTail) Poll() error {
+	if t.published == nil {
+		t.published = map[uint]time.Time{}
+	}
+	since := time.Now().Add(-notificationLookback)
+
+	var recent []models.Notification
+	if err := t.DB.Preload("Actor").Where("created_at > ?", since).Order("id").Find(&recent).Error; err != nil {
+		return err
+	}
+	for _, notification := range recent {
+		if _, ok := t.published[notification.ID]; ok {
+			continue
+		}
+		t.published[notification.ID] = notification.CreatedAt
+		if err := t.Broker.Publish([]uint{notification.UserID}, EventNotification, notifications.NewPayload(notification)); err != nil {
+			return err
+		}
+	}
+	for id, createdAt := range t.published {
+		if !createdAt.After(since) {
+			delete(t.published, id)
+		}
+	}
+	return nil
+}
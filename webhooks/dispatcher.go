@@ -0,0 +1,180 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"gorm.io/gorm"
+)
+
+// Failed deliveries are retried after 30s, 1m, 2m and so on, up to this many attempts in total
+const (
+	MaxDeliveryAttempts = 8
+	initialBackoff      = 30 * time.Second
+	maxBackoff          = time.Hour
+)
+
+// Deliveries claimed by a dispatcher that did not finish within this time are picked up again
+const deliveryLockTimeout = time.Minute
+
+// Only the start of a response body is kept in the delivery log
+const maxResponseBody = 2 << 10
+
+const claimDeliveriesQuery = `
+UPDATE webhook_deliveries SET locked_until = ?
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+	ORDER BY next_attempt_at
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// Body is what webhooks receive. EventID stays the same when an event is delivered again, so receivers can use it
+// to ignore duplicates.
+type Body struct {
+	EventID   uint            `json:"eventID"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Dispatcher posts queued deliveries to their webhooks. Like the notification dispatcher, several of them may run
+// at once. Requests carry the same X-Signature header as notification webhooks.
+type Dispatcher struct {
+	DB     *gorm.DB
+	Client *http.Client
+}
+
+// Sends up to limit deliveries that are due and returns how many were attempted.
+func (d *Dispatcher) DeliverDue(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	err := d.DB.Raw(claimDeliveriesQuery, now.Add(deliveryLockTimeout), models.DeliveryPending, now, now, limit).
+		Scan(&deliveries).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, delivery); err != nil {
+			log.Printf("Could not record webhook delivery %d: %v", delivery.ID, err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// Attempts a single delivery and records the outcome. The returned error is about recording it, failed requests
+// are handled by scheduling a retry.
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) error {
+	var webhook models.Webhook
+	if err := d.DB.First(&webhook, delivery.WebhookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return d.finish(delivery, models.DeliveryFailed, 0, "", "webhook was deleted")
+		}
+		return err
+	}
+	if webhook.DisabledAt != nil {
+		return d.finish(delivery, models.DeliveryFailed, 0, "", "webhook is disabled")
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	status, response, err := d.send(sendCtx, webhook, delivery)
+	if err == nil {
+		if err := d.DB.Model(&webhook).Update("consecutive_failures", 0).Error; err != nil {
+			return err
+		}
+		return d.finish(delivery, models.DeliveryDelivered, status, response, "")
+	}
+
+	// Every failed attempt counts towards disabling the webhook, not only deliveries that gave up
+	recordErr := d.DB.Model(&webhook).Updates(map[string]interface{}{
+		"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+		"disabled_at":          gorm.Expr("CASE WHEN consecutive_failures + 1 >= ? THEN ? ELSE disabled_at END", models.WebhookDisableAfterFailures, time.Now()),
+	}).Error
+	if recordErr != nil {
+		return recordErr
+	}
+	attempts := delivery.Attempts + 1
+	if attempts >= MaxDeliveryAttempts {
+		return d.finish(delivery, models.DeliveryFailed, status, response, err.Error())
+	}
+	backoff := initialBackoff << (attempts - 1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return d.DB.Model(&delivery).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": time.Now().Add(backoff),
+		"locked_until":    nil,
+		"response_status": status,
+		"response_body":   response,
+		"last_error":      err.Error(),
+	}).Error
+}
+
+// Posts the delivery and returns the response status and the start of the response body. Responses other than 2xx
+// are errors.
+func (d *Dispatcher) send(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, string, error) {
+	body, err := json.Marshal(Body{
+		EventID:   delivery.EventID,
+		Event:     delivery.EventName,
+		CreatedAt: delivery.CreatedAt,
+		Data:      json.RawMessage(delivery.Payload),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", "t="+timestamp+",v1="+notifications.SignWebhook(webhook.Secret, timestamp, body))
+	req.Header.Set("X-Webhook-Event", delivery.EventName)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	// The body is cut off at an arbitrary byte and stored as text, which may neither be invalid UTF-8 nor hold NULs
+	responseText := strings.ReplaceAll(strings.ToValidUTF8(string(response), ""), "\x00", "")
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, responseText, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, responseText, nil
+}
+
+func (d *Dispatcher) finish(delivery models.WebhookDelivery, status string, responseStatus int, response string, reason string) error {
+	updates := map[string]interface{}{
+		"status":          status,
+		"attempts":        delivery.Attempts + 1,
+		"locked_until":    nil,
+		"response_status": responseStatus,
+		"response_body":   response,
+		"last_error":      reason,
+	}
+	if status == models.DeliveryDelivered {
+		updates["delivered_at"] = time.Now()
+	}
+	return d.DB.Model(&delivery).Updates(updates).Error
+}
@@ -0,0 +1,133 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coaltail/GoOrders/models"
+	"github.com/coaltail/GoOrders/notifications"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Opens a database that runs no statements. Queries for webhooks return webhook, and the updates made to each
+// table are collected in updates.
+func newTestDB(t *testing.T, webhook models.Webhook, updates map[string][]map[string]interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Callback().Query().After("gorm:query").Register("test:webhook", func(tx *gorm.DB) {
+		if found, ok := tx.Statement.Dest.(*models.Webhook); ok {
+			*found = webhook
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Callback().Update().After("gorm:update").Register("test:updates", func(tx *gorm.DB) {
+		if values, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+			updates[tx.Statement.Table] = append(updates[tx.Statement.Table], values)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDeliverFailedResponse(t *testing.T) {
+	var signature, event, deliveryID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		event = r.Header.Get("X-Webhook-Event")
+		deliveryID = r.Header.Get("X-Webhook-Delivery")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unavailable"))
+	}))
+	defer server.Close()
+
+	webhook := models.Webhook{URL: server.URL, Secret: "secret"}
+	webhook.ID = 3
+	updates := map[string][]map[string]interface{}{}
+	dispatcher := &Dispatcher{DB: newTestDB(t, webhook, updates), Client: server.Client()}
+
+	tests := []struct {
+		name     string
+		attempts int
+		status   interface{}
+	}{
+		{"retried", 0, nil},
+		{"given up", MaxDeliveryAttempts - 1, models.DeliveryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for table := range updates {
+				delete(updates, table)
+			}
+			delivery := models.WebhookDelivery{ID: 7, WebhookID: webhook.ID, EventName: "post.created", Payload: "{}", Attempts: tt.attempts}
+			if err := dispatcher.deliver(context.Background(), delivery); err != nil {
+				t.Fatalf("deliver() error = %v", err)
+			}
+
+			if !strings.HasPrefix(signature, "t=") || !strings.Contains(signature, ",v1=") {
+				t.Errorf("X-Signature = %q", signature)
+			}
+			if event != "post.created" || deliveryID != "7" {
+				t.Errorf("X-Webhook-Event = %q, X-Webhook-Delivery = %q", event, deliveryID)
+			}
+			if len(updates["webhooks"]) != 1 || updates["webhooks"][0]["consecutive_failures"] == nil {
+				t.Errorf("webhook updates = %v, want the failure counted", updates["webhooks"])
+			}
+			if len(updates["webhook_deliveries"]) != 1 {
+				t.Fatalf("delivery updates = %v, want one", updates["webhook_deliveries"])
+			}
+			recorded := updates["webhook_deliveries"][0]
+			if recorded["status"] != tt.status {
+				t.Errorf("status = %v, want %v", recorded["status"], tt.status)
+			}
+			if recorded["attempts"] != tt.attempts+1 {
+				t.Errorf("attempts = %v, want %d", recorded["attempts"], tt.attempts+1)
+			}
+			if recorded["response_status"] != http.StatusInternalServerError || recorded["response_body"] != "unavailable" {
+				t.Errorf("response = %v %v", recorded["response_status"], recorded["response_body"])
+			}
+			if recorded["last_error"] != "webhook responded with 500 Internal Server Error" {
+				t.Errorf("last_error = %v", recorded["last_error"])
+			}
+		})
+	}
+}
+
+func TestSendSignature(t *testing.T) {
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	dispatcher := &Dispatcher{Client: server.Client()}
+	webhook := models.Webhook{URL: server.URL, Secret: "secret"}
+	delivery := models.WebhookDelivery{ID: 1, EventID: 2, EventName: "post.created", Payload: `{"id":1}`, CreatedAt: time.Unix(0, 0)}
+	status, _, err := dispatcher.send(context.Background(), webhook, delivery)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("send() = %d, %v", status, err)
+	}
+
+	timestamp, mac, _ := strings.Cut(strings.TrimPrefix(signature, "t="), ",v1=")
+	if want := notifications.SignWebhook("secret", timestamp, body); mac != want {
+		t.Errorf("signature = %q, want %q", mac, want)
+	}
+}
@@ -0,0 +1,113 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/coaltail/GoOrders/events"
+	"github.com/coaltail/GoOrders/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The events webhooks of regular users can receive, with the user each event concerns. Their webhooks only get the
+// events concerning them.
+var userEvents = map[string]func(events.Event) uint{
+	events.UserFollowedEvent:    func(event events.Event) uint { return event.(events.UserFollowed).FolloweeID },
+	events.FollowRequestedEvent: func(event events.Event) uint { return event.(events.FollowRequested).FolloweeID },
+	events.MessageSentEvent:     func(event events.Event) uint { return event.(events.MessageSent).RecipientID },
+	events.GroupJoinedEvent:     func(event events.Event) uint { return event.(events.GroupJoined).OwnerID },
+}
+
+// The events global webhooks can receive, which are all of them
+var EventTypes = []string{
+	events.UserCreatedEvent,
+	events.UserFollowedEvent,
+	events.FollowRequestedEvent,
+	events.FriendRequestAcceptedEvent,
+	events.PostCreatedEvent,
+	events.MessageSentEvent,
+	events.GroupJoinedEvent,
+}
+
+// Returns the events webhooks of regular users can receive.
+func UserEventTypes() []string {
+	var eventTypes []string
+	for _, eventType := range EventTypes {
+		if _, ok := userEvents[eventType]; ok {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	return eventTypes
+}
+
+// Reports whether webhooks can receive the event, global ones if global is set and regular ones otherwise.
+func IsEventType(name string, global bool) bool {
+	if !global {
+		_, ok := userEvents[name]
+		return ok
+	}
+	for _, eventType := range EventTypes {
+		if eventType == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Register subscribes to every event webhooks can receive, queueing a delivery to each webhook interested in it.
+// It has to be called before the outbox dispatcher starts.
+func Register(bus *events.Bus, db *gorm.DB) {
+	subscribe[events.UserCreated](bus, db)
+	subscribe[events.UserFollowed](bus, db)
+	subscribe[events.FollowRequested](bus, db)
+	subscribe[events.FriendRequestAccepted](bus, db)
+	subscribe[events.PostCreated](bus, db)
+	subscribe[events.MessageSent](bus, db)
+	subscribe[events.GroupJoined](bus, db)
+}
+
+func subscribe[E events.Event](bus *events.Bus, db *gorm.DB) {
+	events.Subscribe(bus, "webhooks", func(ctx context.Context, event E) error {
+		return queueDeliveries(ctx, db, event)
+	})
+}
+
+// Creates a pending delivery of the event for every enabled webhook receiving it. Deliveries are unique per webhook
+// and event, so handling the same event again does not send it twice.
+func queueDeliveries(ctx context.Context, db *gorm.DB, event events.Event) error {
+	query := db.WithContext(ctx).Where("disabled_at IS NULL")
+	if concerns, ok := userEvents[event.EventName()]; ok {
+		query = query.Where("is_global OR owner_id = ?", concerns(event))
+	} else {
+		query = query.Where("is_global")
+	}
+	var webhooks []models.Webhook
+	if err := query.Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var deliveries []models.WebhookDelivery
+	for _, webhook := range webhooks {
+		if !webhook.ReceivesEvent(event.EventName()) {
+			continue
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       events.EventID(ctx),
+			EventName:     event.EventName(),
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
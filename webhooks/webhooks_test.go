@@ -0,0 +1,51 @@
+package webhooks
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/coaltail/GoOrders/events"
+)
+
+func TestUserEventTypes(t *testing.T) {
+	want := []string{events.UserFollowedEvent, events.FollowRequestedEvent, events.MessageSentEvent, events.GroupJoinedEvent}
+	if got := UserEventTypes(); !slices.Equal(got, want) {
+		t.Errorf("UserEventTypes() = %q, want %q", got, want)
+	}
+}
+
+func TestUserEventsConcern(t *testing.T) {
+	tests := []struct {
+		event events.Event
+		want  uint
+	}{
+		{events.UserFollowed{FollowerID: 1, FolloweeID: 2}, 2},
+		{events.FollowRequested{FollowerID: 1, FolloweeID: 2}, 2},
+		{events.MessageSent{MessageID: 3, SenderID: 1, RecipientID: 2}, 2},
+		{events.GroupJoined{GroupID: 3, UserID: 1, OwnerID: 2}, 2},
+	}
+	for _, tt := range tests {
+		if got := userEvents[tt.event.EventName()](tt.event); got != tt.want {
+			t.Errorf("%s concerns user %d, want %d", tt.event.EventName(), got, tt.want)
+		}
+	}
+}
+
+func TestIsEventType(t *testing.T) {
+	tests := []struct {
+		name   string
+		global bool
+		want   bool
+	}{
+		{events.FollowRequestedEvent, false, true},
+		{events.FollowRequestedEvent, true, true},
+		{events.PostCreatedEvent, false, false},
+		{events.PostCreatedEvent, true, true},
+		{"user.deleted", true, false},
+	}
+	for _, tt := range tests {
+		if got := IsEventType(tt.name, tt.global); got != tt.want {
+			t.Errorf("IsEventType(%q, %v) = %v, want %v", tt.name, tt.global, got, tt.want)
+		}
+	}
+}